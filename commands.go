@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+	"github.com/GESkunkworks/gossamer/gossamer"
+)
+
+// subcommands maps the first positional argument to the
+// function that handles the rest of the arguments
+var subcommands = map[string]func(args []string){
+	"list-roles": listRoles,
+	"generate":   generate,
+}
+
+// listRoles runs only the SAML login for a flow and prints
+// the roles in the assertion without calling STS
+func listRoles(args []string) {
+	fs := flag.NewFlagSet("list-roles", flag.ExitOnError)
+	configFile := fs.String("c", "", "path to yml config file")
+	flowName := fs.String("flow", "", "name of the SAML flow to inspect (optional if config has only one SAML flow)")
+	format := fs.String("format", "table", "output format (table or json)")
+	logFile := fs.String("logfile", "gossamer.log.json", "JSON logfile location")
+	logLevel := fs.String("loglevel", "info", "Log level (info or debug)")
+	fs.Parse(args)
+	// keep stdout clean for the report
+	goslogger.SetLoggerStream(false, *logFile, *logLevel, os.Stderr)
+	if *configFile == "" {
+		handle(fmt.Errorf("list-roles requires a config file via '-c'"))
+	}
+	gc = &gossamer.GConf
+	err := gc.ParseConfigFile(*configFile)
+	handle(err)
+	flow, err := gc.GetFlow(*flowName, "saml")
+	handle(err)
+	report, err := flow.ListSAMLRoles()
+	handle(err)
+	switch *format {
+	case "json":
+		err = report.WriteJSON(os.Stdout)
+	case "table":
+		err = report.WriteTable(os.Stdout)
+	default:
+		err = fmt.Errorf("unknown format '%s' please choose one of: table or json", *format)
+	}
+	handle(err)
+}
+
+// generate logs in using a SAML flow and writes out a config
+// whose primary mappings cover every role in the assertion
+func generate(args []string) {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	configFile := fs.String("c", "", "path to yml config file containing the flow")
+	flowName := fs.String("from-flow", "", "name of the SAML flow to log in with (optional if config has only one SAML flow)")
+	aliasFile := fs.String("aliases", "", "optional YAML or CSV file mapping account numbers to friendly names used in profile names")
+	outFile := fs.String("o", "", "file to write the generated config to (default stdout)")
+	logFile := fs.String("logfile", "gossamer.log.json", "JSON logfile location")
+	logLevel := fs.String("loglevel", "info", "Log level (info or debug)")
+	fs.Parse(args)
+	goslogger.SetLoggerStream(false, *logFile, *logLevel, os.Stderr)
+	if *configFile == "" {
+		handle(fmt.Errorf("generate requires a config file via '-c'"))
+	}
+	gc = &gossamer.GConf
+	err := gc.ParseConfigFile(*configFile)
+	handle(err)
+	out, err := gc.GenerateConfigFromFlow(*flowName, *aliasFile)
+	handle(err)
+	if *outFile == "" {
+		_, err = os.Stdout.Write(out)
+		handle(err)
+		return
+	}
+	err = ioutil.WriteFile(*outFile, out, 0644)
+	handle(err)
+	goslogger.Loggo.Info("wrote generated config to file", "filename", *outFile)
+}
@@ -4,16 +4,18 @@ go 1.12
 
 require (
 	github.com/GESkunkworks/acfmgr v1.0.3
-	github.com/aws/aws-sdk-go v1.28.0
+	github.com/aws/aws-sdk-go v1.38.70
+	github.com/beevik/etree v1.1.0
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/inconshreveable/log15 v0.0.0-20200109203555-b30bc20e4fd1
 	github.com/mattn/go-colorable v0.1.4 // indirect
 	github.com/mattn/go-isatty v0.0.11 // indirect
 	github.com/mattn/goveralls v0.0.5 // indirect
 	github.com/modocache/gover v0.0.0-20171022184752-b58185e213c5 // indirect
+	github.com/russellhaering/goxmldsig v1.4.0
 	github.com/sozorogami/gover v0.0.0-20171022184752-b58185e213c5 // indirect
-	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
-	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
 	golang.org/x/tools v0.0.0-20200113202226-4da8041706e9 // indirect
-	gopkg.in/yaml.v2 v2.2.7
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -1,6 +1,7 @@
 package goslogger
 
 import (
+	"io"
 	"os"
 
 	"github.com/inconshreveable/log15"
@@ -12,6 +13,13 @@ var Loggo log15.Logger
 // SetLogger sets up logging globally for the packages involved
 // in the gossamer runtime.
 func SetLogger(daemonFlag bool, logFileS, loglevel string) {
+	SetLoggerStream(daemonFlag, logFileS, loglevel, os.Stdout)
+}
+
+// SetLoggerStream is the same as SetLogger except console logs are
+// written to the provided stream instead of stdout. Useful for commands
+// whose stdout needs to stay clean for output that will be parsed.
+func SetLoggerStream(daemonFlag bool, logFileS, loglevel string, stream io.Writer) {
 	Loggo = log15.New()
 	if daemonFlag {
 		Loggo.SetHandler(
@@ -19,18 +27,18 @@ func SetLogger(daemonFlag bool, logFileS, loglevel string) {
 				log15.LvlInfo,
 				log15.Must.FileHandler(logFileS, log15.JsonFormat())))
 	} else if loglevel == "debug" {
-		// log to stdout and file
+		// log to stream and file
 		Loggo.SetHandler(log15.MultiHandler(
-			log15.StreamHandler(os.Stdout, log15.LogfmtFormat()),
+			log15.StreamHandler(stream, log15.LogfmtFormat()),
 			log15.LvlFilterHandler(
 				log15.LvlDebug,
 				log15.Must.FileHandler(logFileS, log15.JsonFormat()))))
 	} else {
-		// log to stdout and file
+		// log to stream and file
 		Loggo.SetHandler(log15.MultiHandler(
 			log15.LvlFilterHandler(
 				log15.LvlInfo,
-				log15.StreamHandler(os.Stdout, log15.LogfmtFormat())),
+				log15.StreamHandler(stream, log15.LogfmtFormat())),
 			log15.LvlFilterHandler(
 				log15.LvlInfo,
 				log15.Must.FileHandler(logFileS, log15.JsonFormat()))))
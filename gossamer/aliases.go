@@ -0,0 +1,205 @@
+package gossamer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"gopkg.in/yaml.v2"
+)
+
+var accountNumberRegex = regexp.MustCompile("^[0-9]{12}$")
+
+const (
+	// accountAliasCache holds aliases looked up with iam:ListAccountAliases
+	accountAliasCache    = "account_aliases"
+	accountAliasCacheTTL = 24 * time.Hour
+)
+
+// loadAccountAliasFile reads a map of account numbers to friendly
+// aliases from either a YAML map or a two column CSV file. The
+// format is picked by file extension and defaults to YAML.
+func loadAccountAliasFile(filename string) (aliases map[string]string, err error) {
+	aliases = make(map[string]string)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return aliases, err
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		r := csv.NewReader(strings.NewReader(string(data)))
+		r.FieldsPerRecord = -1
+		r.Comment = '#'
+		records, err := r.ReadAll()
+		if err != nil {
+			return aliases, err
+		}
+		for i, rec := range records {
+			if len(rec) < 2 {
+				msg := fmt.Sprintf("account alias file '%s' line %d must have account and alias columns", filename, i+1)
+				err = errors.New(msg)
+				return aliases, err
+			}
+			account := strings.TrimSpace(rec[0])
+			// allow a header row
+			if i == 0 && !accountNumberRegex.MatchString(account) {
+				continue
+			}
+			aliases[account] = strings.TrimSpace(rec[1])
+		}
+	default:
+		err = yaml.Unmarshal(data, &aliases)
+		if err != nil {
+			return aliases, err
+		}
+	}
+	goslogger.Loggo.Debug("loaded account aliases", "filename", filename, "count", len(aliases))
+	return aliases, err
+}
+
+// getResolvedAccountAlias returns an alias that was looked up with
+// iam:ListAccountAliases during this run or a previous one. A blank
+// alias with ok true means the account is known to have no alias.
+func (gc *Config) getResolvedAccountAlias(account string) (alias string, ok bool) {
+	if gc == nil || !gc.ResolveAccountAliases {
+		return alias, ok
+	}
+	gc.aliasMu.Lock()
+	alias, ok = gc.resolvedAccountAliases[account]
+	gc.aliasMu.Unlock()
+	if ok {
+		return alias, ok
+	}
+	return gc.getCache(accountAliasCache).get(account, time.Now())
+}
+
+// setResolvedAccountAlias remembers the account's alias for this run
+// and caches it on disk for later ones
+func (gc *Config) setResolvedAccountAlias(account, alias string) {
+	gc.aliasMu.Lock()
+	if gc.resolvedAccountAliases == nil {
+		gc.resolvedAccountAliases = make(map[string]string)
+	}
+	gc.resolvedAccountAliases[account] = alias
+	gc.aliasMu.Unlock()
+	err := gc.getCache(accountAliasCache).set(account, alias, accountAliasCacheTTL)
+	if err != nil {
+		goslogger.Loggo.Debug("unable to write account alias cache", "error", err)
+	}
+}
+
+// resolveAccountAlias looks up the alias of the mapping's account with
+// the given client unless it's already known from the config or cache.
+// It's best effort so failures are only logged and the account number
+// keeps being used.
+func (m *Mapping) resolveAccountAlias(client iamiface.IAMAPI) {
+	gc := m.parentConfig
+	if gc == nil || !gc.ResolveAccountAliases {
+		return
+	}
+	_, account, err := parseRoleArn(m.RoleArn)
+	if err != nil {
+		return
+	}
+	if _, ok := gc.getAccountAlias(*account); ok {
+		return
+	}
+	if _, ok := gc.getResolvedAccountAlias(*account); ok {
+		return
+	}
+	out, err := client.ListAccountAliases(&iam.ListAccountAliasesInput{})
+	if err != nil {
+		goslogger.Loggo.Debug("unable to look up account alias", "roleArn", m.RoleArn, "error", err)
+		return
+	}
+	alias := ""
+	if len(out.AccountAliases) > 0 && out.AccountAliases[0] != nil {
+		alias = *out.AccountAliases[0]
+	}
+	goslogger.Loggo.Debug("resolved account alias", "account", *account, "alias", alias)
+	gc.setResolvedAccountAlias(*account, alias)
+}
+
+// learnAccountAlias uses the mapping's freshly assumed credentials
+// to look up the alias of its account
+func (m *Mapping) learnAccountAlias() {
+	if m.credential == nil || m.parentConfig == nil || !m.parentConfig.ResolveAccountAliases {
+		return
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{Credentials: convertSCredsToCreds(m.credential)},
+	})
+	if err != nil {
+		return
+	}
+	m.resolveAccountAlias(iam.New(sess))
+}
+
+// refreshProfileNames renders the generated profile names of the
+// assumptions' mappings again right after they're assumed so aliases
+// resolved while assuming them are used on this run instead of the next
+// one. Later runs find the alias in the cache so the name stays the same.
+func (a *Assumptions) refreshProfileNames() {
+	if a == nil || a.parentConfig == nil || !a.parentConfig.ResolveAccountAliases {
+		return
+	}
+	for i := range a.Mappings {
+		a.Mappings[i].refreshProfileName()
+	}
+}
+
+// refreshProfileName renders the mapping's generated profile name again.
+// Names set by the user are left alone and the new name is refused if
+// it would collide with another mapping's or if a secondary mapping
+// still refers to the old one as its sponsor.
+func (m *Mapping) refreshProfileName() {
+	if !m.generatedProfileName {
+		return
+	}
+	name, err := m.generateProfileName()
+	if err != nil || name == m.ProfileName {
+		return
+	}
+	old := m.ProfileName
+	f := m.parentFlow
+	if f != nil && f.isSponsorRef(old) {
+		goslogger.Loggo.Warn("keeping generated profile name since a secondary mapping uses it as its sponsor", "roleArn", m.RoleArn, "profileName", old, "aliasName", name)
+		return
+	}
+	m.ProfileName = name
+	if f != nil {
+		if err := f.validateMappingIDs(); err != nil {
+			goslogger.Loggo.Warn("keeping generated profile name since the alias name collides", "roleArn", m.RoleArn, "profileName", old, "error", err)
+			m.ProfileName = old
+			return
+		}
+	}
+	goslogger.Loggo.Info("updated generated profile name with resolved account alias", "roleArn", m.RoleArn, "old", old, "new", name)
+}
+
+// getDescription returns the description written with the mapping's
+// profile entries which includes the account alias when it's known
+func (m *Mapping) getDescription() string {
+	description := ""
+	if m.parentFlow != nil {
+		description = m.parentFlow.Name
+	}
+	_, account, err := parseRoleArn(m.RoleArn)
+	if err != nil {
+		return description
+	}
+	if alias, ok := m.parentConfig.getAccountAlias(*account); ok {
+		description = fmt.Sprintf("%s (%s)", description, alias)
+	}
+	return description
+}
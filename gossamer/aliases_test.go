@@ -0,0 +1,145 @@
+package gossamer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func (m *mockIAMClient) ListAccountAliases(input *iam.ListAccountAliasesInput) (output *iam.ListAccountAliasesOutput, err error) {
+	m.calls++
+	if m.SVCErr != nil {
+		return output, m.SVCErr
+	}
+	output = &iam.ListAccountAliasesOutput{AccountAliases: aws.StringSlice(m.accountAliases)}
+	return output, err
+}
+
+func TestResolveAccountAlias(t *testing.T) {
+	initLog()
+	dir, err := ioutil.TempDir("", "gossamer-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cases := []struct {
+		roleArn   string
+		client    *mockIAMClient
+		alias     string
+		wantCalls int
+	}{
+		{
+			roleArn:   "arn:aws:iam::123456789012:role/admin",
+			client:    &mockIAMClient{accountAliases: []string{"prod"}},
+			alias:     "prod",
+			wantCalls: 1,
+		},
+		{
+			// already resolved so no call
+			roleArn: "arn:aws:iam::123456789012:role/readonly",
+			client:  &mockIAMClient{accountAliases: []string{"other"}},
+			alias:   "prod",
+		},
+		{
+			// configured aliases win over lookups
+			roleArn: "arn:aws:iam::210987654321:role/admin",
+			client:  &mockIAMClient{accountAliases: []string{"dev-from-aws"}},
+			alias:   "dev",
+		},
+		{
+			// failures fall back to the account number
+			roleArn:   "arn:aws:iam::333333333333:role/admin",
+			client:    &mockIAMClient{SVCErr: errors.New("AccessDenied")},
+			wantCalls: 1,
+		},
+		{
+			// accounts without an alias are remembered too
+			roleArn:   "arn:aws:iam::444444444444:role/admin",
+			client:    &mockIAMClient{},
+			wantCalls: 1,
+		},
+	}
+	gc := &Config{
+		CacheDir:              dir,
+		ResolveAccountAliases: true,
+		AccountAliases:        map[string]string{"210987654321": "dev"},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		m := Mapping{RoleArn: c.roleArn, parentConfig: gc}
+		m.resolveAccountAlias(c.client)
+		if c.client.calls != c.wantCalls {
+			t.Errorf("unexpected number of calls: want %d, got %d", c.wantCalls, c.client.calls)
+		}
+		_, account, _ := parseRoleArn(c.roleArn)
+		alias, _ := gc.getAccountAlias(*account)
+		if alias != c.alias {
+			t.Errorf("unexpected alias: want '%s', got '%s'", c.alias, alias)
+		}
+	}
+	// a later run picks the aliases up from the disk cache
+	later := &Config{CacheDir: dir, ResolveAccountAliases: true}
+	if alias, _ := later.getAccountAlias("123456789012"); alias != "prod" {
+		t.Errorf("expected cached alias 'prod' but got '%s'", alias)
+	}
+	client := &mockIAMClient{accountAliases: []string{"unused"}}
+	(&Mapping{RoleArn: "arn:aws:iam::444444444444:role/admin", parentConfig: later}).resolveAccountAlias(client)
+	if client.calls != 0 {
+		t.Errorf("expected cached empty alias to skip the lookup but got %d calls", client.calls)
+	}
+	// and ignores it when resolving is turned off
+	off := &Config{CacheDir: dir}
+	if alias, ok := off.getAccountAlias("123456789012"); ok {
+		t.Errorf("expected no alias with resolving off but got '%s'", alias)
+	}
+}
+
+func TestRefreshProfileName(t *testing.T) {
+	initLog()
+	dir, err := ioutil.TempDir("", "gossamer-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := newTestChainFlow([]Mapping{
+		{RoleArn: "arn:aws:iam::123456789012:role/admin"},
+		{RoleArn: "arn:aws:iam::123456789012:role/readonly", ProfileName: "mine"},
+		// the alias name is already taken by the user
+		{RoleArn: "arn:aws:iam::123456789012:role/viewer"},
+		{RoleArn: "arn:aws:iam::210987654321:role/viewer", ProfileName: "prod-viewer"},
+		// a secondary refers to the old name
+		{RoleArn: "arn:aws:iam::123456789012:role/deploy"},
+	}, []Mapping{
+		{RoleArn: "arn:aws:iam::333333333333:role/workload", ProfileName: "workload", Sponsor: "123456789012-deploy"},
+	})
+	gc := f.parentConfig
+	gc.ProfileNameTemplate = "{{.AccountAlias}}-{{.RoleName}}"
+	gc.ResolveAccountAliases = true
+	gc.CacheDir = dir
+	for i := range f.PAss.Mappings {
+		if err := f.PAss.Mappings[i].validate(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	generated := &f.PAss.Mappings[0]
+	if generated.ProfileName != "123456789012-admin" {
+		t.Fatalf("unexpected profile name before resolving: '%s'", generated.ProfileName)
+	}
+	gc.setResolvedAccountAlias("123456789012", "prod")
+	f.PAss.refreshProfileNames()
+	expected := []string{"prod-admin", "mine", "123456789012-viewer", "prod-viewer", "123456789012-deploy"}
+	for i, want := range expected {
+		fmt.Println("test case: ", i)
+		if got := f.PAss.Mappings[i].ProfileName; got != want {
+			t.Errorf("unexpected profile name for '%s': want '%s', got '%s'", f.PAss.Mappings[i].RoleArn, want, got)
+		}
+	}
+	if d := generated.getDescription(); d != "chain (prod)" {
+		t.Errorf("unexpected description: '%s'", d)
+	}
+}
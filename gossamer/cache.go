@@ -0,0 +1,128 @@
+package gossamer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+)
+
+// diskCache is a small key/value store with per entry expiry that is
+// kept in a JSON file so lookups can be skipped on later runs. A nil
+// diskCache is valid and simply never has anything in it.
+type diskCache struct {
+	mu      sync.Mutex
+	path    string
+	loaded  bool
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+func newDiskCache(path string) *diskCache {
+	return &diskCache{path: path, entries: make(map[string]cacheEntry)}
+}
+
+// load reads the cache file the first time it's needed. A missing
+// or unreadable file just means an empty cache.
+func (c *diskCache) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &c.entries)
+	if err != nil {
+		goslogger.Loggo.Debug("ignoring unreadable cache file", "path", c.path, "error", err)
+		c.entries = make(map[string]cacheEntry)
+	}
+}
+
+// get returns the value for key if it's present and hasn't expired
+func (c *diskCache) get(key string, now time.Time) (val string, ok bool) {
+	if c == nil {
+		return val, ok
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	e, ok := c.entries[key]
+	if !ok || !now.Before(e.Expires) {
+		return val, false
+	}
+	return e.Value, ok
+}
+
+// set stores the value for ttl and writes the cache back to disk
+func (c *diskCache) set(key, val string, ttl time.Duration) (err error) {
+	if c == nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	now := time.Now()
+	c.entries[key] = cacheEntry{Value: val, Expires: now.Add(ttl)}
+	// drop anything stale while we're here
+	for k, e := range c.entries {
+		if !now.Before(e.Expires) {
+			delete(c.entries, k)
+		}
+	}
+	data, err := json.MarshalIndent(c.entries, "", "    ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(c.path), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, data, 0600)
+}
+
+// getCacheDir returns the directory gossamer keeps its caches in which
+// is cache_dir from the config or 'gossamer' in the user's cache dir
+func (gc *Config) getCacheDir() (dir string) {
+	if len(gc.CacheDir) > 0 {
+		return gc.CacheDir
+	}
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return dir
+	}
+	return filepath.Join(base, "gossamer")
+}
+
+// getCache returns the named cache shared by everything using the config
+// or nil if the config is missing or no cache directory can be found
+func (gc *Config) getCache(name string) *diskCache {
+	if gc == nil {
+		return nil
+	}
+	gc.cacheMu.Lock()
+	defer gc.cacheMu.Unlock()
+	if c, ok := gc.caches[name]; ok {
+		return c
+	}
+	dir := gc.getCacheDir()
+	if len(dir) < 1 {
+		goslogger.Loggo.Debug("no cache directory available so not caching", "cache", name)
+		return nil
+	}
+	if gc.caches == nil {
+		gc.caches = make(map[string]*diskCache)
+	}
+	c := newDiskCache(filepath.Join(dir, name+".json"))
+	gc.caches[name] = c
+	return c
+}
@@ -30,7 +30,7 @@ func detectNilInt64Pointer(label string, pointer *int64) (isnil bool, err error)
 	return isnil, err
 }
 
-func assumeSAMLRoleWithSession(principalArn, roleArn, roleSessionName, assertion *string, duration *int64, client stsiface.STSAPI) (*sts.Credentials, error) {
+func assumeSAMLRoleWithSession(principalArn, roleArn, roleSessionName, assertion *string, duration *int64, sa *sessionAttributes, client stsiface.STSAPI) (*sts.Credentials, error) {
 	var c *sts.Credentials
 	if isnil, err := detectNilStringPointer("principalArn", principalArn); isnil {
 		return c, err
@@ -54,29 +54,98 @@ func assumeSAMLRoleWithSession(principalArn, roleArn, roleSessionName, assertion
 		SAMLAssertion:   assertion,
 		DurationSeconds: duration,
 	}
+	sa.applySAML(&input)
 	result, err := client.AssumeRoleWithSAML(&input)
 	if err == nil && *duration > 3600 {
 		goslogger.Loggo.Debug("Successfully assumed session extended SAML session duration", "duration", *duration)
 	}
 	if err != nil && detectedDurationProblem(err) {
-		goslogger.Loggo.Debug("defaulting to standard duration")
+		goslogger.Loggo.Warn("requested duration was rejected so retrying with the default", "roleArn", *roleArn, "requested", *duration)
 		// warn and bump the duration down to default
 		input := sts.AssumeRoleWithSAMLInput{
 			PrincipalArn:  principalArn,
 			RoleArn:       roleArn,
 			SAMLAssertion: assertion,
 		}
+		sa.applySAML(&input)
 		result, err = client.AssumeRoleWithSAML(&input)
 	}
 	if err != nil {
-		return c, err
+		return c, detectedPackedPolicyProblem(roleArn, err)
 	}
 	return result.Credentials, err
 }
 
+// sessionAttributes holds the optional parts of an AssumeRole call
+// that ride along with the session such as tags, source identity
+// and session policies
+type sessionAttributes struct {
+	tags              []*sts.Tag
+	transitiveTagKeys []*string
+	sourceIdentity    *string
+	externalID        *string
+	policy            *string
+	policyArns        []*sts.PolicyDescriptorType
+}
+
+// setSessionPolicy copies the mapping's session policies
+func (sa *sessionAttributes) setSessionPolicy(m *Mapping) {
+	if len(m.policyJSON) > 0 {
+		sa.policy = aws.String(m.policyJSON)
+	}
+	sa.policyArns = m.getPolicyDescriptors()
+}
+
+// applySAML sets the session policies on the input since
+// they're the only attributes AssumeRoleWithSAML accepts
+func (sa *sessionAttributes) applySAML(input *sts.AssumeRoleWithSAMLInput) {
+	if sa == nil {
+		return
+	}
+	if sa.policy != nil {
+		input.Policy = sa.policy
+	}
+	if len(sa.policyArns) > 0 {
+		input.PolicyArns = sa.policyArns
+	}
+}
+
+// apply sets whatever session attributes are present on the input
+func (sa *sessionAttributes) apply(input *sts.AssumeRoleInput) {
+	if sa == nil {
+		return
+	}
+	goslogger.Loggo.Debug("applying session attributes to AssumeRole input",
+		"tags", len(sa.tags),
+		"transitiveTagKeys", len(sa.transitiveTagKeys),
+		"sourceIdentity", aws.StringValue(sa.sourceIdentity),
+		"externalID", sa.externalID != nil,
+		"policy", sa.policy != nil,
+		"policyArns", len(sa.policyArns),
+	)
+	if len(sa.tags) > 0 {
+		input.Tags = sa.tags
+	}
+	if len(sa.transitiveTagKeys) > 0 {
+		input.TransitiveTagKeys = sa.transitiveTagKeys
+	}
+	if sa.sourceIdentity != nil && len(*sa.sourceIdentity) > 0 {
+		input.SourceIdentity = sa.sourceIdentity
+	}
+	if sa.externalID != nil && len(*sa.externalID) > 0 {
+		input.ExternalId = sa.externalID
+	}
+	if sa.policy != nil {
+		input.Policy = sa.policy
+	}
+	if len(sa.policyArns) > 0 {
+		input.PolicyArns = sa.policyArns
+	}
+}
+
 // assumeRoleWithClient takes an existing session and sets up the assume role inputs for
 // the API call
-func assumeRoleWithClient(roleArn, roleSessionName *string, duration *int64, client stsiface.STSAPI) (*sts.Credentials, error) {
+func assumeRoleWithClient(roleArn, roleSessionName *string, duration *int64, sa *sessionAttributes, client stsiface.STSAPI) (*sts.Credentials, error) {
 	var c *sts.Credentials
 	if isnil, err := detectNilInt64Pointer("duration", duration); isnil {
 		return c, err
@@ -99,6 +168,7 @@ func assumeRoleWithClient(roleArn, roleSessionName *string, duration *int64, cli
 		RoleSessionName: roleSessionName,
 		DurationSeconds: duration,
 	}
+	sa.apply(&input)
 	aso, err := client.AssumeRole(&input)
 	if err == nil && *duration > 3600 {
 		goslogger.Loggo.Debug("Successfully assumed extended session duration.")
@@ -106,15 +176,16 @@ func assumeRoleWithClient(roleArn, roleSessionName *string, duration *int64, cli
 	// detect any errors we can handle
 	if err != nil && detectedDurationProblem(err) {
 		// warn and bump the duration down to default
-		goslogger.Loggo.Debug("defaulting to standard duration")
+		goslogger.Loggo.Warn("requested duration was rejected so retrying with the default", "roleArn", *roleArn, "requested", *duration)
 		input := sts.AssumeRoleInput{
 			RoleArn:         roleArn,
 			RoleSessionName: roleSessionName,
 		}
+		sa.apply(&input)
 		aso, err = client.AssumeRole(&input)
 	}
 	if err != nil {
-		return c, err
+		return c, detectedPackedPolicyProblem(roleArn, err)
 	}
 	return aso.Credentials, err
 }
@@ -138,11 +209,26 @@ func detectedDurationProblem(err error) bool {
 // to try and auto generate the role session name from a
 // established client.
 func generateRoleSessionName(client stsiface.STSAPI) string {
+	return roleSessionNameFromArn(getCallerArn(client))
+}
+
+// getCallerArn returns the ARN of the client's identity
+// or a blank string if it can't be determined
+func getCallerArn(client stsiface.STSAPI) string {
 	callerIdentity, err := client.GetCallerIdentity(&sts.GetCallerIdentityInput{})
 	if err != nil {
+		return ""
+	}
+	return aws.StringValue(callerIdentity.Arn)
+}
+
+// roleSessionNameFromArn builds the default role session
+// name from the caller's ARN
+func roleSessionNameFromArn(arn string) string {
+	if len(arn) < 1 {
 		return "gossamer"
 	}
-	arnParts := strings.Split(*callerIdentity.Arn, "/")
+	arnParts := strings.Split(arn, "/")
 	return "gossamer-" + arnParts[len(arnParts)-1]
 }
 
@@ -155,6 +241,14 @@ func (f *Flow) getPermSession() (sess *session.Session, err error) {
 		return f.sharedSession, err
 	}
 	goslogger.Loggo.Debug("no session detected for flow, establishing new")
+	if f.FromFlowConfig != nil {
+		sess, err = f.getFromFlowSession()
+		if err != nil {
+			return sess, err
+		}
+		f.sharedSession = sess
+		return sess, err
+	}
 	if f.PermCredsConfig != nil {
 		if len(f.PermCredsConfig.ProfileName) > 0 && len(f.Region) > 0 {
 			goslogger.Loggo.Debug("using profile for session with specific region", "flowname", f.Name)
@@ -200,7 +294,11 @@ func (f *Flow) getPermSession() (sess *session.Session, err error) {
 	// try to get the role session name from the session we just got
 	// because we want the pure name before the MFA session if any
 	stsClient := sts.New(sess)
-	f.PAss.setRoleSessionName(generateRoleSessionName(stsClient))
+	f.callerArn = getCallerArn(stsClient)
+	err = f.setPrimaryRoleSessionName(roleSessionNameFromArn(f.callerArn))
+	if err != nil {
+		return sess, err
+	}
 	// now we need to check and see if we need to establish MFA on the session
 	goslogger.Loggo.Debug("checking for presence of MFA")
 	if f.PermCredsConfig.MFA != nil {
@@ -239,3 +337,48 @@ func (f *Flow) getPermSession() (sess *session.Session, err error) {
 	f.sharedSession = sess
 	return sess, err
 }
+
+// getFromFlowSession builds a session from the credentials of a mapping
+// in another flow. The role session name and session attributes are
+// carried over from that mapping for the same reason secondaries inherit
+// them from primaries.
+func (f *Flow) getFromFlowSession() (sess *session.Session, err error) {
+	source, err := f.parentConfig.GetFlow(f.FromFlowConfig.Flow, "")
+	if err != nil {
+		return sess, err
+	}
+	sponsor := source.findMappingByRef(f.FromFlowConfig.Mapping)
+	if sponsor == nil {
+		msg := fmt.Sprintf("from_flow mapping '%s' not found in flow '%s'", f.FromFlowConfig.Mapping, source.Name)
+		err = errors.New(msg)
+		return sess, err
+	}
+	cred, err := sponsor.getCredential()
+	if err != nil {
+		msg := fmt.Sprintf("error getting creds from flow '%s': %s", source.Name, err)
+		err = errors.New(msg)
+		return sess, err
+	}
+	goslogger.Loggo.Debug("using mapping from another flow for session", "flowname", f.Name, "sourceFlow", source.Name, "roleArn", sponsor.RoleArn)
+	config := aws.Config{Credentials: convertSCredsToCreds(cred)}
+	if len(f.Region) > 0 {
+		config.Region = &f.Region
+	}
+	sess, err = session.NewSessionWithOptions(session.Options{Config: config})
+	if err != nil {
+		return sess, err
+	}
+	rsn := *sponsor.parentAssumptions.getRoleSessionName()
+	if len(rsn) < 1 || len(f.RoleSessionName) > 0 {
+		f.callerArn = getCallerArn(sts.New(sess))
+	}
+	if len(rsn) < 1 {
+		rsn = roleSessionNameFromArn(f.callerArn)
+	}
+	err = f.setPrimaryRoleSessionName(rsn)
+	if err != nil {
+		return sess, err
+	}
+	f.PAss.setSessionAttributes(sponsor.parentAssumptions.getSessionAttributes())
+	return sess, err
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/GESkunkworks/gossamer/goslogger"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 )
@@ -45,7 +46,8 @@ func getFakeAssumedRoleUser() *sts.AssumedRoleUser {
 
 type mockSTSClient struct {
 	stsiface.STSAPI
-	SVCErr error
+	SVCErr  error
+	assumed []string
 }
 
 func (m *mockSTSClient) GetCallerIdentity(input *sts.GetCallerIdentityInput) (output *sts.GetCallerIdentityOutput, err error) {
@@ -80,6 +82,7 @@ func (m *mockSTSClient) AssumeRoleWithSAML(input *sts.AssumeRoleWithSAMLInput) (
 }
 
 func (m *mockSTSClient) AssumeRole(input *sts.AssumeRoleInput) (output *sts.AssumeRoleOutput, err error) {
+	m.assumed = append(m.assumed, aws.StringValue(input.RoleArn))
 	if m.SVCErr != nil {
 		return output, m.SVCErr
 	}
@@ -148,6 +151,7 @@ func TestAssumeSAMLRoleWithSession(t *testing.T) {
 			c.roleSessionName,
 			c.assertion,
 			c.duration,
+			nil,
 			mockSTSClient,
 		)
 		if err != nil {
@@ -214,6 +218,7 @@ func TestAssumeRoleWithClient(t *testing.T) {
 			c.roleArn,
 			c.roleSessionName,
 			c.duration,
+			nil,
 			mockSTSClient,
 		)
 		if err != nil {
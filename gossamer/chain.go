@@ -0,0 +1,269 @@
+package gossamer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+)
+
+// sponsorRef returns the reference the mapping uses to find its
+// sponsor. The older sponsor_creds_arn is honored if sponsor is blank.
+func (m *Mapping) sponsorRef() string {
+	if len(m.Sponsor) > 0 {
+		return m.Sponsor
+	}
+	return m.SponsorCredsArn
+}
+
+// matchesRef returns true if the reference names this mapping
+// either by role ARN or by profile name (including the generated
+// '<account>_<role>' name when no profile name is set)
+func (m *Mapping) matchesRef(ref string) bool {
+	return ref == m.RoleArn || ref == m.mappingID()
+}
+
+// findMappingByRef returns a pointer to the mapping whose profile name
+// matches the reference or failing that the first one with a matching
+// role ARN. Profile names win since the same role can be mapped twice.
+func (a *Assumptions) findMappingByRef(ref string) *Mapping {
+	if a == nil {
+		return nil
+	}
+	for i := range a.Mappings {
+		if a.Mappings[i].mappingID() == ref {
+			return &a.Mappings[i]
+		}
+	}
+	for i := range a.Mappings {
+		if a.Mappings[i].RoleArn == ref {
+			return &a.Mappings[i]
+		}
+	}
+	return nil
+}
+
+// findMappingByRef looks through the flow's primary and then
+// secondary mappings for one matching the reference
+func (f *Flow) findMappingByRef(ref string) *Mapping {
+	if m := f.PAss.findMappingByRef(ref); m != nil {
+		return m
+	}
+	return f.SAss.findMappingByRef(ref)
+}
+
+// isSponsorRef returns true if any secondary mapping names the
+// reference as its sponsor
+func (f *Flow) isSponsorRef(ref string) bool {
+	if f.SAss == nil {
+		return false
+	}
+	for i := range f.SAss.Mappings {
+		if f.SAss.Mappings[i].sponsorRef() == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDynamicPrimaries returns true if primary mappings will be added
+// from a SAML assertion or account or role discovery at runtime, meaning
+// a sponsor that can't be found in the config may still show up later.
+func (f *Flow) hasDynamicPrimaries() bool {
+	if f.PAss == nil {
+		return false
+	}
+	return f.PAss.Organizations != nil || f.PAss.DiscoverRoles != nil || (f.SAMLConfig != nil && (f.PAss.AllRoles || len(f.PAss.Include) > 0))
+}
+
+// resolveSponsors links every secondary mapping to the mapping that
+// will sponsor it. Sponsors can be primary mappings or other secondary
+// mappings so chains can be any depth. When strict is false sponsors
+// that can't be found are tolerated since they may come from a SAML
+// assertion later. Cycles are always an error.
+func (f *Flow) resolveSponsors(strict bool) (err error) {
+	if f.SAss == nil {
+		return err
+	}
+	var problems []string
+	for i := range f.SAss.Mappings {
+		m := &f.SAss.Mappings[i]
+		m.sponsor = nil
+		m.sponsorErr = nil
+		ref := m.sponsorRef()
+		switch {
+		case len(ref) < 1 && f.PAss != nil && len(f.PAss.Mappings) == 1:
+			// if there's only one set of primary creds we can infer
+			goslogger.Loggo.Debug("no sponsor specified so using only primary mapping", "roleArn", m.RoleArn)
+			m.sponsor = &f.PAss.Mappings[0]
+		case len(ref) < 1:
+			if !strict && f.hasDynamicPrimaries() {
+				continue
+			}
+			msg := fmt.Sprintf("no sponsor specified for secondary mapping '%s' and too many primary mappings to make an inference", m.RoleArn)
+			m.sponsorErr = errors.New(msg)
+		default:
+			// prefer other secondaries so a profile name can't be shadowed
+			// by a primary role with the same generated name
+			if s := f.SAss.findMappingByRef(ref); s != nil {
+				m.sponsor = s
+			} else if p := f.PAss.findMappingByRef(ref); p != nil {
+				m.sponsor = p
+			} else if !strict && f.hasDynamicPrimaries() {
+				goslogger.Loggo.Debug("sponsor not found in config, may come from SAML assertion", "roleArn", m.RoleArn, "sponsor", ref)
+				continue
+			} else {
+				msg := fmt.Sprintf("sponsor '%s' for secondary mapping '%s' not found", ref, m.RoleArn)
+				m.sponsorErr = errors.New(msg)
+			}
+		}
+		if m.sponsorErr != nil {
+			problems = append(problems, m.sponsorErr.Error())
+		}
+	}
+	cycleErr := f.SAss.checkSponsorCycles()
+	if cycleErr != nil {
+		problems = append(problems, cycleErr.Error())
+	}
+	if len(problems) > 0 {
+		err = fmt.Errorf("flow '%s' has sponsor problems: %s", f.Name, strings.Join(problems, "; "))
+	}
+	return err
+}
+
+// checkSponsorCycles walks the sponsor links between secondary
+// mappings and returns an error describing the first cycle found. Every
+// mapping in a cycle gets the error as its sponsorErr so it fails
+// instead of waiting on a sponsor that will never be ready.
+func (a *Assumptions) checkSponsorCycles() (err error) {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*Mapping]int)
+	members := make(map[*Mapping]bool)
+	for i := range a.Mappings {
+		members[&a.Mappings[i]] = true
+	}
+	var visit func(m *Mapping, path []*Mapping)
+	visit = func(m *Mapping, path []*Mapping) {
+		switch state[m] {
+		case visiting:
+			var arns []string
+			start := 0
+			for i, p := range path {
+				if p == m {
+					start = i
+				}
+				arns = append(arns, p.RoleArn)
+			}
+			cycleErr := fmt.Errorf("sponsor cycle detected: %s", strings.Join(append(arns, m.RoleArn), " -> "))
+			for _, p := range path[start:] {
+				p.sponsorErr = cycleErr
+			}
+			if err == nil {
+				err = cycleErr
+			}
+			return
+		case visited:
+			return
+		}
+		state[m] = visiting
+		if members[m.sponsor] {
+			visit(m.sponsor, append(path, m))
+		}
+		state[m] = visited
+	}
+	for i := range a.Mappings {
+		visit(&a.Mappings[i], nil)
+	}
+	return err
+}
+
+// assumeMappingsChained assumes every mapping as soon as its sponsor
+// is ready. Mappings whose sponsors are in other assumptions (e.g.,
+// primary) start right away. Mappings marked to skip are left alone
+// and mappings with sponsor problems fail without waiting.
+func (a *Assumptions) assumeMappingsChained() {
+	done := make(map[*Mapping]chan struct{})
+	for i := range a.Mappings {
+		done[&a.Mappings[i]] = make(chan struct{})
+	}
+	q := make(chan assumptionResult)
+	a.groupSharedAssumptions()
+	count := 0
+	for i := range a.Mappings {
+		m := &a.Mappings[i]
+		if m.skip {
+			close(done[m])
+			continue
+		}
+		count++
+		go func(m *Mapping) {
+			defer close(done[m])
+			// the sponsor may be in a cycle and never be ready
+			if m.sponsorErr != nil {
+				q <- assumptionResult{roleArn: m.RoleArn, profileName: m.ProfileName, err: m.sponsorErr}
+				return
+			}
+			if ch, ok := done[m.sponsor]; ok {
+				<-ch
+			}
+			if ch, ok := done[m.sharedFrom]; ok {
+				<-ch
+			}
+			m.assumeChan(q)
+		}(m)
+	}
+	for i := 0; i < count; i++ {
+		result := <-q
+		goslogger.Loggo.Info(
+			"got result of assumption",
+			"message", result.message,
+			"error", result.err,
+			"profileName", result.profileName,
+		)
+	}
+}
+
+// OrderedFlows returns the config's flows ordered so that any flow
+// using from_flow runs after the flow it depends on. Otherwise the
+// order from the config file is kept.
+func (gc *Config) OrderedFlows() (ordered []*Flow, err error) {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*Flow]int)
+	var visit func(f *Flow, path []string) error
+	visit = func(f *Flow, path []string) error {
+		path = append(path, f.Name)
+		switch state[f] {
+		case visiting:
+			return fmt.Errorf("flow dependency cycle detected: %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[f] = visiting
+		if f.FromFlowConfig != nil {
+			source, err := gc.GetFlow(f.FromFlowConfig.Flow, "")
+			if err != nil {
+				return fmt.Errorf("flow '%s' depends on missing flow: %s", f.Name, err)
+			}
+			if err := visit(source, path); err != nil {
+				return err
+			}
+		}
+		state[f] = visited
+		ordered = append(ordered, f)
+		return nil
+	}
+	for _, f := range gc.Flows {
+		err = visit(f, nil)
+		if err != nil {
+			return ordered, err
+		}
+	}
+	return ordered, err
+}
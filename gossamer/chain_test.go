@@ -0,0 +1,196 @@
+package gossamer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestChainFlow(primaries, secondaries []Mapping) *Flow {
+	f := Flow{
+		Name:            "chain",
+		PermCredsConfig: &PermCredsConfig{},
+		PAss:            &Assumptions{Mappings: primaries},
+		SAss:            &Assumptions{Mappings: secondaries},
+	}
+	gc := Config{Flows: []*Flow{&f}}
+	f.setRelationships(&gc)
+	return &f
+}
+
+func TestResolveSponsors(t *testing.T) {
+	initLog()
+	hub := Mapping{RoleArn: "arn:aws:iam::111111111111:role/hub", ProfileName: "hub"}
+	other := Mapping{RoleArn: "arn:aws:iam::111111111111:role/other"}
+	cases := []struct {
+		name        string
+		primaries   []Mapping
+		secondaries []Mapping
+		errContains string
+		sponsors    []string
+	}{
+		{
+			name:      "hub spoke workload by profile name and arn",
+			primaries: []Mapping{hub, other},
+			secondaries: []Mapping{
+				{RoleArn: "arn:aws:iam::333333333333:role/workload", Sponsor: "spoke"},
+				{RoleArn: "arn:aws:iam::222222222222:role/spoke", ProfileName: "spoke", Sponsor: "hub"},
+				{RoleArn: "arn:aws:iam::444444444444:role/legacy", SponsorCredsArn: "arn:aws:iam::111111111111:role/other"},
+				{RoleArn: "arn:aws:iam::555555555555:role/generated", Sponsor: "111111111111_other"},
+			},
+			sponsors: []string{
+				"arn:aws:iam::222222222222:role/spoke",
+				"arn:aws:iam::111111111111:role/hub",
+				"arn:aws:iam::111111111111:role/other",
+				"arn:aws:iam::111111111111:role/other",
+			},
+		},
+		{
+			name:        "inferred from only primary",
+			primaries:   []Mapping{hub},
+			secondaries: []Mapping{{RoleArn: "arn:aws:iam::222222222222:role/spoke"}},
+			sponsors:    []string{"arn:aws:iam::111111111111:role/hub"},
+		},
+		{
+			name:        "cannot infer",
+			primaries:   []Mapping{hub, other},
+			secondaries: []Mapping{{RoleArn: "arn:aws:iam::222222222222:role/spoke"}},
+			errContains: "too many primary mappings",
+		},
+		{
+			name:        "missing sponsor",
+			primaries:   []Mapping{hub},
+			secondaries: []Mapping{{RoleArn: "arn:aws:iam::222222222222:role/spoke", Sponsor: "nope"}},
+			errContains: "sponsor 'nope'",
+		},
+		{
+			name:      "cycle",
+			primaries: []Mapping{hub},
+			secondaries: []Mapping{
+				{RoleArn: "arn:aws:iam::222222222222:role/a", ProfileName: "a", Sponsor: "c"},
+				{RoleArn: "arn:aws:iam::222222222222:role/b", ProfileName: "b", Sponsor: "a"},
+				{RoleArn: "arn:aws:iam::222222222222:role/c", ProfileName: "c", Sponsor: "b"},
+			},
+			errContains: "sponsor cycle detected",
+		},
+		{
+			name:        "self",
+			primaries:   []Mapping{hub},
+			secondaries: []Mapping{{RoleArn: "arn:aws:iam::222222222222:role/a", ProfileName: "a", Sponsor: "a"}},
+			errContains: "sponsor cycle detected",
+		},
+	}
+	for _, c := range cases {
+		fmt.Println("test case: ", c.name)
+		f := newTestChainFlow(c.primaries, c.secondaries)
+		err := f.resolveSponsors(true)
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("%s: expected error containing '%s' but got '%v'", c.name, c.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+			continue
+		}
+		for i, want := range c.sponsors {
+			got := f.SAss.Mappings[i].sponsor
+			if got == nil || got.RoleArn != want {
+				t.Errorf("%s: unexpected sponsor for '%s': want '%s', got '%v'", c.name, f.SAss.Mappings[i].RoleArn, want, got)
+			}
+		}
+	}
+}
+
+func TestResolveSponsorsDynamicPrimaries(t *testing.T) {
+	initLog()
+	f := newTestChainFlow(nil, []Mapping{{RoleArn: "arn:aws:iam::222222222222:role/spoke", Sponsor: "111111111111_hub"}})
+	f.PermCredsConfig = nil
+	f.SAMLConfig = &SAMLConfig{}
+	f.PAss.AllRoles = true
+	if err := f.resolveSponsors(false); err != nil {
+		t.Errorf("expected sponsor from assertion to be tolerated at validate time: %s", err)
+	}
+	if err := f.resolveSponsors(true); err == nil {
+		t.Error("expected missing sponsor to be an error at runtime")
+	}
+}
+
+func TestOrderedFlows(t *testing.T) {
+	initLog()
+	newFlow := func(name, from string) *Flow {
+		f := Flow{Name: name, PAss: &Assumptions{}}
+		if len(from) > 0 {
+			f.FromFlowConfig = &FromFlowConfig{Flow: from, Mapping: "landing"}
+		} else {
+			f.PermCredsConfig = &PermCredsConfig{}
+			f.PAss.Mappings = []Mapping{{RoleArn: "arn:aws:iam::111111111111:role/landing", ProfileName: "landing"}}
+		}
+		return &f
+	}
+	gc := Config{Flows: []*Flow{newFlow("team-b", "identity"), newFlow("team-c", "team-b"), newFlow("identity", "")}}
+	gc.setRelationships()
+	ordered, err := gc.OrderedFlows()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var names []string
+	for _, f := range ordered {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "identity,team-b,team-c" {
+		t.Errorf("unexpected order: %v", names)
+	}
+	if _, err := gc.Flows[0].Validate(); err != nil {
+		t.Errorf("unexpected validation error: %s", err)
+	}
+	if _, err := gc.Flows[1].Validate(); err == nil {
+		t.Error("expected error for from_flow mapping missing from source flow")
+	}
+	gc.Flows[2].FromFlowConfig = &FromFlowConfig{Flow: "team-c", Mapping: "landing"}
+	gc.Flows[2].PermCredsConfig = nil
+	if _, err := gc.OrderedFlows(); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected cycle error but got '%v'", err)
+	}
+}
+
+func TestAssumeMappingsChainedCycle(t *testing.T) {
+	initLog()
+	f := newTestChainFlow(
+		[]Mapping{{RoleArn: "arn:aws:iam::111111111111:role/hub", ProfileName: "hub"}},
+		[]Mapping{
+			{RoleArn: "arn:aws:iam::222222222222:role/a", ProfileName: "a", Sponsor: "c"},
+			{RoleArn: "arn:aws:iam::222222222222:role/b", ProfileName: "b", Sponsor: "a"},
+			{RoleArn: "arn:aws:iam::222222222222:role/c", ProfileName: "c", Sponsor: "b"},
+			// waits on a member of the cycle
+			{RoleArn: "arn:aws:iam::333333333333:role/d", ProfileName: "d", Sponsor: "a"},
+		},
+	)
+	if err := f.resolveSponsors(true); err == nil || !strings.Contains(err.Error(), "sponsor cycle detected") {
+		t.Fatalf("expected cycle error but got '%v'", err)
+	}
+	for i, m := range f.SAss.Mappings {
+		fmt.Println("test case: ", i)
+		inCycle := m.ProfileName != "d"
+		if (m.sponsorErr != nil) != inCycle {
+			t.Errorf("unexpected sponsor error for '%s': %v", m.ProfileName, m.sponsorErr)
+		}
+	}
+	finished := make(chan struct{})
+	go func() {
+		f.SAss.assumeMappingsChained()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("assuming mappings in a sponsor cycle never finished")
+	}
+	for _, m := range f.SAss.Mappings {
+		if _, err := m.getCredential(); err == nil {
+			t.Errorf("expected no credential for '%s'", m.ProfileName)
+		}
+	}
+}
@@ -2,12 +2,13 @@ package gossamer
 
 import (
 	"bufio"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"regexp"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/GESkunkworks/acfmgr"
@@ -27,26 +28,57 @@ var GConf Config
 // Config is an internal struct for storing
 // configuration needed to run this application
 type Config struct {
-	OutFile string  `yaml:"output_file"`
-	Flows   []*Flow `yaml:"flows"`
+	OutFile                  string                    `yaml:"output_file"`
+	ConfigFile               string                    `yaml:"config_file,omitempty"`
+	MaxConcurrentFlows       int                       `yaml:"max_concurrent_flows,omitempty"`
+	CacheDir                 string                    `yaml:"cache_dir,omitempty"`
+	ProfileNameTemplate      string                    `yaml:"profile_name_template,omitempty"`
+	ProfileNameNormalization *ProfileNameNormalization `yaml:"profile_name_normalization,omitempty"`
+	AccountAliases           map[string]string         `yaml:"account_aliases,omitempty"`
+	AccountAliasFile         string                    `yaml:"account_alias_file,omitempty"`
+	ResolveAccountAliases    bool                      `yaml:"resolve_account_aliases,omitempty"`
+	Flows                    []*Flow                   `yaml:"flows"`
+	selection                *Selection
+	cacheMu                  sync.Mutex
+	caches                   map[string]*diskCache
+	fileAccountAliases       map[string]string
+	aliasMu                  sync.Mutex
+	resolvedAccountAliases   map[string]string
+	accountNames             map[string]string
 }
 
 // Flow describes an authentication flow and can
 // be one of many types. It contains the user's
 // desired auth flow behavior via keys or saml.
 type Flow struct {
-	Name                 string           `yaml:"name"`
-	SAMLConfig           *SAMLConfig      `yaml:"saml_config,omitempty"`
-	PermCredsConfig      *PermCredsConfig `yaml:"permanent,omitempty"`
-	PAss                 *Assumptions     `yaml:"primary_assumptions,omitempty"`
-	SAss                 *Assumptions     `yaml:"secondary_assumptions,omitempty"`
-	DurationSeconds      int64            `yaml:"session_duration_seconds,omitempty"`
-	Region               string           `yaml:"region,omitempty"`
-	DoNotPropagateRegion bool             `yaml:"do_not_propagate_region"`
-	AllowFailure         bool             `yaml:"allow_failure"`
-	credsType            string
-	parentConfig         *Config
-	sharedSession        *session.Session
+	Name                      string                    `yaml:"name"`
+	SAMLConfig                *SAMLConfig               `yaml:"saml_config,omitempty"`
+	PermCredsConfig           *PermCredsConfig          `yaml:"permanent,omitempty"`
+	FromFlowConfig            *FromFlowConfig           `yaml:"from_flow,omitempty"`
+	PAss                      *Assumptions              `yaml:"primary_assumptions,omitempty"`
+	SAss                      *Assumptions              `yaml:"secondary_assumptions,omitempty"`
+	DurationSeconds           int64                     `yaml:"session_duration_seconds,omitempty"`
+	Duration                  string                    `yaml:"duration,omitempty"`
+	Region                    string                    `yaml:"region,omitempty"`
+	Regions                   []string                  `yaml:"regions,omitempty"`
+	RegionProfileNameTemplate string                    `yaml:"region_profile_name_template,omitempty"`
+	ProfileNameTemplate       string                    `yaml:"profile_name_template,omitempty"`
+	ProfileNameNormalization  *ProfileNameNormalization `yaml:"profile_name_normalization,omitempty"`
+	DoNotPropagateRegion      bool                      `yaml:"do_not_propagate_region"`
+	AllowFailure              bool                      `yaml:"allow_failure"`
+	Tags                      []string                  `yaml:"tags,omitempty"`
+	ExternalID                *CParam                   `yaml:"external_id,omitempty"`
+	SessionTags               map[string]string         `yaml:"session_tags,omitempty"`
+	TransitiveTagKeys         []string                  `yaml:"transitive_tag_keys,omitempty"`
+	SourceIdentity            string                    `yaml:"source_identity,omitempty"`
+	PropagateSAMLAttributes   bool                      `yaml:"propagate_saml_attributes,omitempty"`
+	RoleSessionName           string                    `yaml:"role_session_name,omitempty"`
+	credsType                 string
+	callerArn                 string
+	configEntries             []configProfileEntry
+	requiredRefs              []string
+	parentConfig              *Config
+	sharedSession             *session.Session
 }
 
 func (f *Flow) setRelationships(gc *Config) (err error) {
@@ -78,6 +110,40 @@ func (pcc *PermCredsConfig) validate() (ok bool, err error) {
 	return ok, err
 }
 
+// FromFlowConfig holds information about starting a flow from the
+// credentials of a mapping that was assumed by another flow in the
+// same config. Mapping can be either a role ARN or profile name.
+type FromFlowConfig struct {
+	Flow    string `yaml:"flow"`
+	Mapping string `yaml:"mapping"`
+}
+
+func (ffc *FromFlowConfig) validate(f *Flow) (ok bool, err error) {
+	if len(ffc.Flow) < 1 || len(ffc.Mapping) < 1 {
+		err = errors.New("from_flow requires both 'flow' and 'mapping'")
+		return ok, err
+	}
+	if f.PAss == nil {
+		err = errors.New("from_flow requires primary_assumptions")
+		return ok, err
+	}
+	if ffc.Flow == f.Name {
+		err = errors.New("from_flow cannot reference its own flow")
+		return ok, err
+	}
+	source, err := f.parentConfig.GetFlow(ffc.Flow, "")
+	if err != nil {
+		return ok, err
+	}
+	if source.findMappingByRef(ffc.Mapping) == nil && !source.hasDynamicPrimaries() {
+		msg := fmt.Sprintf("from_flow mapping '%s' not found in flow '%s'", ffc.Mapping, ffc.Flow)
+		err = errors.New(msg)
+		return ok, err
+	}
+	ok = true
+	return ok, err
+}
+
 // SAMLConfig holds specific parameters for SAML configuration
 type SAMLConfig struct {
 	Username                     *CParam `yaml:"username"`
@@ -85,17 +151,40 @@ type SAMLConfig struct {
 	URL                          *CParam `yaml:"url"`
 	Target                       *CParam `yaml:"target"`
 	AllowMappingDurationOverride bool    `yaml:"allow_mapping_duration_override,omitempty"`
+	IdPCertificate               *CParam `yaml:"idp_certificate,omitempty"`
 }
 
 func (sc *SAMLConfig) validate() (ok bool, err error) {
 	//TODO: Add some validation here
+	if sc.IdPCertificate != nil && sc.IdPCertificate.Source == "prompt" {
+		err = errors.New("idp_certificate cannot be sourced from a prompt please use 'config', 'env', or 'file'")
+		return ok, err
+	}
 	return ok, err
 }
 
+// getIdPCertificate gathers and parses the pinned IdP certificate if
+// one was configured. A nil certificate means verification is disabled.
+func (sc *SAMLConfig) getIdPCertificate() (cert *x509.Certificate, err error) {
+	if sc.IdPCertificate == nil {
+		return cert, err
+	}
+	raw, err := sc.IdPCertificate.gather()
+	if err != nil {
+		return cert, err
+	}
+	cert, err = parseIdPCertificate(raw)
+	if err != nil {
+		msg := fmt.Sprintf("error parsing idp_certificate: %s", err)
+		err = errors.New(msg)
+	}
+	return cert, err
+}
+
 // CParam provides a way to identify sources for config parameters
 // that are more robust that simple key value. For example you can
 // say that a configuration parameter is sourced from an environment
-// variable or from a prompt in addition to just raw value.
+// variable, a file, or from a prompt in addition to just raw value.
 // It has a gather() method which is used to retrieve its value.
 type CParam struct {
 	name   string
@@ -105,12 +194,16 @@ type CParam struct {
 	gathered   bool
 	result     string
 	parentflow string
+	// mappings assumed concurrently can share a param
+	mu sync.Mutex
 }
 
 // gather looks at the source of the config parameter
 // and attempts to retrieve the value using that method.
 // It returns the value as a string and any errors.
 func (c *CParam) gather() (val string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	// if we've already grabbed it in the past
 	// we'll just return it again
 	if c.gathered {
@@ -139,6 +232,17 @@ func (c *CParam) gather() (val string, err error) {
 		}
 		c.gathered = true
 		return c.result, err
+	case "file":
+		var b []byte
+		b, err = ioutil.ReadFile(c.Value)
+		if err != nil {
+			message := fmt.Sprintf("unable to read file '%s' specified for param: %s", c.Value, err)
+			err = errors.New(message)
+			return c.result, err
+		}
+		c.result = strings.TrimSpace(string(b))
+		c.gathered = true
+		return c.result, err
 	case "prompt":
 		fmt.Printf("gathering value for flow '%s': ", c.parentflow)
 		switch c.name {
@@ -163,6 +267,55 @@ func (c *CParam) gather() (val string, err error) {
 	return val, err
 }
 
+// gatherPrompts collects the value of every parameter in the flow that
+// is sourced from a prompt so that flows can later run concurrently
+// without their prompts interleaving
+func (f *Flow) gatherPrompts() (err error) {
+	var params []*CParam
+	if f.SAMLConfig != nil {
+		// reject an unusable config before prompting for anything
+		_, err = f.SAMLConfig.validate()
+		if err != nil {
+			return err
+		}
+		params = append(params,
+			f.SAMLConfig.Username,
+			f.SAMLConfig.Password,
+			f.SAMLConfig.URL,
+			f.SAMLConfig.Target,
+		)
+	}
+	if f.PermCredsConfig != nil && f.PermCredsConfig.MFA != nil {
+		params = append(params, f.PermCredsConfig.MFA.Serial, f.PermCredsConfig.MFA.Token)
+	}
+	params = append(params, f.ExternalID)
+	for _, a := range []*Assumptions{f.PAss, f.SAss} {
+		if a == nil {
+			continue
+		}
+		for i := range a.Mappings {
+			params = append(params, a.Mappings[i].ExternalID)
+		}
+		// discovered mappings share these so they're gathered up front
+		if a.Organizations != nil {
+			params = append(params, a.Organizations.ExternalID)
+		}
+		if a.DiscoverRoles != nil {
+			params = append(params, a.DiscoverRoles.ExternalID)
+		}
+	}
+	for _, c := range params {
+		if c == nil || c.Source != "prompt" {
+			continue
+		}
+		_, err = c.gather()
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
 // Assumptions holds the configuration for the roles that
 // will be assumed using both the primary and secondary credentials
 // Primary:
@@ -171,17 +324,29 @@ func (c *CParam) gather() (val string, err error) {
 // Secondary:
 // Secondary assumptions' mappings rely on sponsor credentials
 // that are presumed to be obtained from primary mappings
+// Include and Exclude rules pick which roles from the SAML assertion
+// are assumed when they have no explicit mapping. When Include is set
+// it takes the place of AllRoles.
 type Assumptions struct {
-	AllRoles             bool      `yaml:"all_roles"`
-	Mappings             []Mapping `yaml:"mappings"`
-	doNotPropagateRegion bool
-	atype                string
-	roleSessionName      string
-	parentRegion         string
-	allowFailure         bool
-	durationSeconds      int64
-	parentFlow           *Flow
-	parentConfig         *Config
+	AllRoles                 bool                      `yaml:"all_roles"`
+	Include                  []*RoleSelector           `yaml:"include,omitempty"`
+	Exclude                  []*RoleSelector           `yaml:"exclude,omitempty"`
+	Mappings                 []Mapping                 `yaml:"mappings"`
+	Matrix                   []*MatrixMapping          `yaml:"matrix,omitempty"`
+	Organizations            *OrganizationsDiscovery   `yaml:"organizations,omitempty"`
+	DiscoverRoles            *RoleDiscovery            `yaml:"discover_roles,omitempty"`
+	ProfileNameTemplate      string                    `yaml:"profile_name_template,omitempty"`
+	ProfileNameNormalization *ProfileNameNormalization `yaml:"profile_name_normalization,omitempty"`
+	Lazy                     bool                      `yaml:"lazy,omitempty"`
+	doNotPropagateRegion     bool
+	atype                    string
+	roleSessionName          string
+	sessionAttributes        *sessionAttributes
+	parentRegion             string
+	allowFailure             bool
+	durationSeconds          int64
+	parentFlow               *Flow
+	parentConfig             *Config
 }
 
 func (a *Assumptions) setRelationships(f *Flow, gc *Config) (err error) {
@@ -204,11 +369,37 @@ func (a *Assumptions) getRoleSessionName() *string {
 	return (&a.roleSessionName)
 }
 
+func (a *Assumptions) setSessionAttributes(sa *sessionAttributes) {
+	a.sessionAttributes = sa
+}
+
+func (a *Assumptions) getSessionAttributes() *sessionAttributes {
+	return a.sessionAttributes
+}
+
 func (a *Assumptions) assumeMappingsConcurrent() {
+	a.assumeMappingsFrom(0)
+}
+
+// assumeMappingsFrom concurrently assumes the mappings from the given
+// index on. Earlier mappings are left alone so that mappings added after
+// the first round (e.g., discovered roles) can be assumed by themselves.
+func (a *Assumptions) assumeMappingsFrom(start int) {
 	q := make(chan assumptionResult)
-	if len(a.Mappings) > 0 {
+	a.groupSharedAssumptions()
+	var todo, shared []*Mapping
+	for i := start; i < len(a.Mappings); i++ {
+		switch {
+		case a.Mappings[i].skip:
+		case a.Mappings[i].sharedFrom != nil:
+			shared = append(shared, &a.Mappings[i])
+		default:
+			todo = append(todo, &a.Mappings[i])
+		}
+	}
+	if len(todo) > 0 {
 		goslogger.Loggo.Info("assuming first role to establish initial session")
-		go a.Mappings[0].assumeChan(q)
+		go todo[0].assumeChan(q)
 		// wait for the response so we can have a cred for the rest
 		result := <-q
 		goslogger.Loggo.Info(
@@ -218,12 +409,12 @@ func (a *Assumptions) assumeMappingsConcurrent() {
 			"profileName", result.profileName,
 		)
 	}
-	if len(a.Mappings) > 1 {
+	if len(todo) > 1 {
 		// now do the rest
-		for i := 1; i < len(a.Mappings); i++ {
-			go a.Mappings[i].assumeChan(q)
+		for i := 1; i < len(todo); i++ {
+			go todo[i].assumeChan(q)
 		}
-		for i := 1; i < len(a.Mappings); i++ {
+		for i := 1; i < len(todo); i++ {
 			result := <-q
 			goslogger.Loggo.Info(
 				"got result of assumption",
@@ -233,6 +424,15 @@ func (a *Assumptions) assumeMappingsConcurrent() {
 			)
 		}
 	}
+	// mappings sharing an assumption just copy the credentials
+	for _, m := range shared {
+		err := m.assume()
+		goslogger.Loggo.Info(
+			"got result of shared assumption",
+			"error", err,
+			"profileName", m.ProfileName,
+		)
+	}
 }
 
 // convertSCredstoCreds converts credentials from the sts to the credentials package
@@ -251,25 +451,47 @@ func (f *Flow) NoSAss() bool {
 	return f.SAss == nil
 }
 
-func (a *Assumptions) getMappingCredential(roleArn string) (cred *sts.Credentials, err error) {
-	found := false
+// samlPrincipalMatches returns true if the mapping can be used with the
+// given principal from the assertion. Mappings that don't pin a principal
+// can be used with any principal but only one per run.
+func (m *Mapping) samlPrincipalMatches(principalArn string) bool {
+	if len(m.PrincipalArn) > 0 && m.PrincipalArn != principalArn {
+		return false
+	}
+	if len(m.samlPrincipalArn) > 0 && m.samlPrincipalArn != principalArn {
+		return false
+	}
+	return true
+}
+
+// principalPinned returns true if a mapping for the role sets the
+// principal as its principal_arn
+func (a *Assumptions) principalPinned(roleArn, principalArn string) bool {
 	for _, mapping := range a.Mappings {
-		if mapping.RoleArn == roleArn {
-			found = true
-			cred, err = mapping.getCredential()
+		if mapping.RoleArn == roleArn && mapping.PrincipalArn == principalArn {
+			return true
 		}
 	}
-	if !found {
-		msg := fmt.Sprintf("credentials not found for %s", roleArn)
-		err = errors.New(msg)
+	return false
+}
+
+// samlMappingMatches returns true if the mapping is the one to use for the
+// role and principal pair. Pinned mappings win so an unpinned mapping is
+// only tied to a principal that no pinned mapping claims no matter which
+// order the assertion lists them in.
+func (a *Assumptions) samlMappingMatches(m *Mapping, roleArn, principalArn string) bool {
+	if m.RoleArn != roleArn || !m.samlPrincipalMatches(principalArn) {
+		return false
 	}
-	return cred, err
+	return len(m.PrincipalArn) > 0 || !a.principalPinned(roleArn, principalArn)
 }
 
-func (a *Assumptions) getMapping(roleArn string) (ok bool, mappingResult *Mapping) {
-	for _, mapping := range a.Mappings {
-		if mapping.RoleArn == roleArn {
-			mappingResult = &mapping
+// getMapping looks up a mapping by the role and principal pair
+// that comes from the SAML assertion.
+func (a *Assumptions) getMapping(roleArn, principalArn string) (ok bool, mappingResult *Mapping) {
+	for i := range a.Mappings {
+		if a.samlMappingMatches(&a.Mappings[i], roleArn, principalArn) {
+			mappingResult = &a.Mappings[i]
 			ok = true
 			return ok, mappingResult
 		}
@@ -277,9 +499,20 @@ func (a *Assumptions) getMapping(roleArn string) (ok bool, mappingResult *Mappin
 	return ok, mappingResult
 }
 
+// roleMappedViaOtherPrincipal returns true if a mapping for the role has
+// already been tied to a different principal from the same assertion
+func (a *Assumptions) roleMappedViaOtherPrincipal(roleArn, principalArn string) bool {
+	for _, mapping := range a.Mappings {
+		if mapping.RoleArn == roleArn && len(mapping.samlPrincipalArn) > 0 && mapping.samlPrincipalArn != principalArn {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *Assumptions) setMappingSAMLStuff(roleArn, principalArn string, sc *samlSessionConfig) {
 	for i := range a.Mappings {
-		if a.Mappings[i].RoleArn == roleArn {
+		if a.samlMappingMatches(&a.Mappings[i], roleArn, principalArn) {
 			a.Mappings[i].samlPrincipalArn = principalArn
 			a.Mappings[i].parentSAMLConfig = sc
 			a.Mappings[i].userDefined = true
@@ -289,6 +522,7 @@ func (a *Assumptions) setMappingSAMLStuff(roleArn, principalArn string, sc *saml
 
 // GetAcfmgrProfileInputs converts all flow's mappings into Acfmgr ProfileEntryInput for easy use with AcfMgr package
 func (f *Flow) GetAcfmgrProfileInputs() (pfis []*acfmgr.ProfileEntryInput, err error) {
+	f.configEntries = nil
 	primary, err := f.PAss.getAcfmgrProfileInputs()
 	if err != nil {
 		return pfis, err
@@ -309,29 +543,67 @@ func (f *Flow) GetAcfmgrProfileInputs() (pfis []*acfmgr.ProfileEntryInput, err e
 func (a *Assumptions) getAcfmgrProfileInputs() (pfis []*acfmgr.ProfileEntryInput, err error) {
 	countSuccess := 0
 	countFail := 0
+	countWanted := 0
 	total := len(pfis)
 	goslogger.Loggo.Debug("entering GetAcfmgrProfileInputs()...")
-	for _, mapping := range a.Mappings {
-		if !mapping.NoOutput {
+	for i := range a.Mappings {
+		mapping := &a.Mappings[i]
+		if mapping.unselected {
+			goslogger.Loggo.Debug("Skipping writing cred not in selection", "roleArn", mapping.RoleArn)
+			continue
+		}
+		countWanted++
+		if !mapping.NoOutput && a.Lazy {
+			entries, err := mapping.lazyProfileEntries()
+			if err != nil {
+				goslogger.Loggo.Error("unable to build lazy profile", "roleArn", mapping.RoleArn, "error", err)
+				countFail++
+				continue
+			}
+			if a.parentFlow != nil {
+				a.parentFlow.configEntries = append(a.parentFlow.configEntries, entries...)
+			}
+			countSuccess++
+		} else if !mapping.NoOutput {
 			cred, err := mapping.getCredential()
 			if err != nil {
 				countFail++
-			} else {
+				continue
+			}
+			entries, err := mapping.profileEntries()
+			if err != nil {
+				goslogger.Loggo.Error("unable to build profile entries", "roleArn", mapping.RoleArn, "error", err)
+				countFail++
+				continue
+			}
+			tmpl, err := mapping.profileEntryTemplate(cred)
+			if err != nil {
+				goslogger.Loggo.Error("unable to build profile entry template", "roleArn", mapping.RoleArn, "error", err)
+				countFail++
+				continue
+			}
+			// one assumption can be written as several entries (e.g., one per region)
+			for _, e := range entries {
 				profileInput := acfmgr.ProfileEntryInput{
 					Credential:       cred,
-					ProfileEntryName: mapping.ProfileName,
-					Region:           mapping.Region,
+					ProfileEntryName: e.profileName,
+					Region:           e.region,
+					OutputFormat:     mapping.Output,
 					AssumeRoleARN:    mapping.RoleArn,
-					Description:      a.parentFlow.Name,
+					Description:      mapping.getDescription(),
+					TemplateOverride: tmpl,
 				}
 				pfis = append(pfis, &profileInput)
+				if a.parentFlow != nil {
+					a.parentFlow.configEntries = append(a.parentFlow.configEntries, mapping.configProfileEntry(e))
+				}
 				goslogger.Loggo.Debug("put credential in write queue",
 					"RoleArn", mapping.RoleArn,
-					"ProfileName", mapping.ProfileName,
+					"ProfileName", e.profileName,
 					"cred", *profileInput.Credential.AccessKeyId,
 				)
-				countSuccess++
 			}
+			countSuccess++
 		} else {
 			goslogger.Loggo.Info("Skipping writing cred per configuration directive", "roleArn", mapping.RoleArn)
 		}
@@ -339,7 +611,7 @@ func (a *Assumptions) getAcfmgrProfileInputs() (pfis []*acfmgr.ProfileEntryInput
 	if countSuccess < total {
 		goslogger.Loggo.Info("failed to obtain some credentials to add to write queue", "total", total, "countFail", countFail, "countSuccess", countSuccess)
 	}
-	if countSuccess == 0 {
+	if countSuccess == 0 && countWanted > 0 {
 		if !a.allowFailure {
 			msg := "failed to queue any desired credentials"
 			err = errors.New(msg)
@@ -369,33 +641,58 @@ func (a *Assumptions) setDoNotPropagateRegion(dnp bool) {
 func (f *Flow) Validate() (valid bool, err error) {
 	// first detect type
 	switch {
-	case f.SAMLConfig != nil && f.PermCredsConfig == nil:
+	case f.SAMLConfig != nil && f.PermCredsConfig == nil && f.FromFlowConfig == nil:
 		f.credsType = "saml"
 		valid, err = f.SAMLConfig.validate()
 		if err != nil {
 			return valid, err
 		}
-	case f.SAMLConfig == nil && f.PermCredsConfig != nil:
+	case f.SAMLConfig == nil && f.PermCredsConfig != nil && f.FromFlowConfig == nil:
 		f.credsType = "permanent"
 		valid, err = f.PermCredsConfig.validate()
 		if err != nil {
 			return valid, err
 		}
+	case f.SAMLConfig == nil && f.PermCredsConfig == nil && f.FromFlowConfig != nil:
+		f.credsType = "from_flow"
+		valid, err = f.FromFlowConfig.validate(f)
+		if err != nil {
+			return valid, err
+		}
 	default:
-		err = errors.New("only one type of creds can be used for starting each flow please choose one of: permanent or saml")
+		err = errors.New("only one type of creds can be used for starting each flow please choose one of: permanent, saml, or from_flow")
 		return valid, err
 	}
 	goslogger.Loggo.Info("detected type for flow", "flowName", f.Name, "type", f.credsType)
 	if len(f.Region) > 1 {
 		goslogger.Loggo.Info("flow: detected user specified region so validating it")
-		var validRegion = regexp.MustCompile(`\w{2}-([a-z]*-){1,2}\d{1}`)
-		if validRegion.MatchString(f.Region) {
+		if validRegionRegex.MatchString(f.Region) {
 			valid = true
 		} else {
 			err = errors.New("region must match '\\w{2}-([a-z]*-){1,2}\\d{1}'")
 			return valid, err
 		}
 	}
+	err = f.validateRoleSessionNames()
+	if err != nil {
+		return valid, err
+	}
+	err = f.validateDurationSettings()
+	if err != nil {
+		return valid, err
+	}
+	err = f.validateProfileNames()
+	if err != nil {
+		return valid, err
+	}
+	err = f.validateProfileSettings()
+	if err != nil {
+		return valid, err
+	}
+	err = f.validateMappingIDs()
+	if err != nil {
+		return valid, err
+	}
 	// set a default session duration if none is specified
 	var blankDuration int64
 	if f.DurationSeconds == blankDuration {
@@ -403,6 +700,29 @@ func (f *Flow) Validate() (valid bool, err error) {
 	}
 	// set parentRegion and inheritance setting on assumptions if set on flow
 	if f.PAss != nil {
+		err = f.PAss.validateSelectors()
+		if err != nil {
+			return valid, err
+		}
+		if f.PAss.AllRoles && len(f.PAss.Include) > 0 {
+			goslogger.Loggo.Warn("all_roles is ignored since include rules are set", "flow", f.Name)
+		}
+		err = f.PAss.Organizations.validate(f.PAss)
+		if err != nil {
+			return valid, err
+		}
+		err = f.PAss.DiscoverRoles.validate(f.PAss)
+		if err != nil {
+			return valid, err
+		}
+		err = f.PAss.validateLazy()
+		if err != nil {
+			return valid, err
+		}
+		err = f.PAss.loadSessionPolicies()
+		if err != nil {
+			return valid, err
+		}
 		f.PAss.atype = "primary"
 		goslogger.Loggo.Debug("setting primary assumption duration", "duration", f.DurationSeconds)
 		f.PAss.durationSeconds = f.DurationSeconds
@@ -417,6 +737,26 @@ func (f *Flow) Validate() (valid bool, err error) {
 		}
 	}
 	if f.SAss != nil {
+		err = f.SAss.validateSelectors()
+		if err != nil {
+			return valid, err
+		}
+		err = f.SAss.Organizations.validate(f.SAss)
+		if err != nil {
+			return valid, err
+		}
+		err = f.SAss.DiscoverRoles.validate(f.SAss)
+		if err != nil {
+			return valid, err
+		}
+		err = f.SAss.validateLazy()
+		if err != nil {
+			return valid, err
+		}
+		err = f.SAss.loadSessionPolicies()
+		if err != nil {
+			return valid, err
+		}
 		f.SAss.atype = "secondary"
 		goslogger.Loggo.Debug("setting secondary assumption duration", "duration", f.DurationSeconds)
 		f.SAss.durationSeconds = f.DurationSeconds
@@ -429,6 +769,16 @@ func (f *Flow) Validate() (valid bool, err error) {
 		if f.AllowFailure {
 			f.SAss.allowFailure = true
 		}
+		// catch missing sponsors and cycles before anything is assumed
+		err = f.resolveSponsors(!f.hasDynamicPrimaries())
+		if err != nil {
+			return valid, err
+		}
+	}
+	// now that inheritance is set up make sure durations are sane
+	err = f.validateDurations()
+	if err != nil {
+		return valid, err
 	}
 	return valid, err
 }
@@ -444,6 +794,10 @@ func (gc *Config) ParseConfigFile(filename string) (err error) {
 	if err != nil {
 		return err
 	}
+	err = gc.loadAccountAliases()
+	if err != nil {
+		return err
+	}
 	// add labels to CParams so we can sanely prompt for them
 	for _, flow := range gc.Flows {
 		if flow.SAMLConfig != nil {
@@ -458,6 +812,11 @@ func (gc *Config) ParseConfigFile(filename string) (err error) {
 
 			flow.SAMLConfig.Target.name = "Target"
 			flow.SAMLConfig.Target.parentflow = flow.Name
+
+			if flow.SAMLConfig.IdPCertificate != nil {
+				flow.SAMLConfig.IdPCertificate.name = "IdPCertificate"
+				flow.SAMLConfig.IdPCertificate.parentflow = flow.Name
+			}
 		}
 		if flow.PermCredsConfig != nil {
 			if flow.PermCredsConfig.MFA != nil {
@@ -468,6 +827,25 @@ func (gc *Config) ParseConfigFile(filename string) (err error) {
 				flow.PermCredsConfig.MFA.Token.parentflow = flow.Name
 			}
 		}
+		if flow.ExternalID != nil {
+			flow.ExternalID.name = "ExternalID"
+			flow.ExternalID.parentflow = flow.Name
+		}
+		for _, a := range []*Assumptions{flow.PAss, flow.SAss} {
+			if a == nil {
+				continue
+			}
+			for i := range a.Mappings {
+				if a.Mappings[i].ExternalID != nil {
+					a.Mappings[i].ExternalID.name = "ExternalID for " + a.Mappings[i].RoleArn
+					a.Mappings[i].ExternalID.parentflow = flow.Name
+				}
+			}
+			err = a.expandMatrix(flow, gc)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	err = gc.setRelationships()
 	return err
@@ -548,6 +926,10 @@ type GossFlags struct {
 	VersionFlag               bool
 	ForceRefresh              bool
 	SessionDuration           int64
+	MaxConcurrentFlows        int
+	FlowSelector              string
+	ProfileSelector           string
+	TagSelector               string
 }
 
 func (gc *Config) setRelationships() (err error) {
@@ -559,3 +941,40 @@ func (gc *Config) setRelationships() (err error) {
 	}
 	return err
 }
+
+// GetFlow returns the flow with the given name. If name is blank and
+// there is only one flow of the given credsType ("saml", "permanent" or
+// blank for any) then that flow is returned.
+func (gc *Config) GetFlow(name, credsType string) (flow *Flow, err error) {
+	var candidates []*Flow
+	for _, f := range gc.Flows {
+		if len(name) > 0 {
+			if f.Name == name {
+				return f, err
+			}
+			continue
+		}
+		switch credsType {
+		case "saml":
+			if f.SAMLConfig == nil {
+				continue
+			}
+		case "permanent":
+			if f.PermCredsConfig == nil {
+				continue
+			}
+		}
+		candidates = append(candidates, f)
+	}
+	if len(name) > 0 {
+		msg := fmt.Sprintf("no flow named '%s' found in config", name)
+		err = errors.New(msg)
+		return flow, err
+	}
+	if len(candidates) != 1 {
+		msg := fmt.Sprintf("found %d candidate flows please specify one by name", len(candidates))
+		err = errors.New(msg)
+		return flow, err
+	}
+	return candidates[0], err
+}
@@ -0,0 +1,252 @@
+package gossamer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+)
+
+// managedSectionHeader marks a profile section as owned by gossamer. It's
+// the same header acfmgr puts on credentials file entries.
+var managedSectionHeader = []string{
+	"# DO NOT EDIT",
+	"# ACFMGR MANAGED SECTION",
+	"# (Will be overwritten regularly)",
+	"####################################################",
+}
+
+// configProfileEntry is a profile section written to the config_file
+type configProfileEntry struct {
+	profileName string
+	roleArn     string
+	description string
+	settings    []string
+}
+
+// configProfileEntry returns the config_file section for one of the
+// mapping's profile entries with its region, output and extra settings
+func (m *Mapping) configProfileEntry(e profileEntry) configProfileEntry {
+	var settings []string
+	if len(e.region) > 0 {
+		settings = append(settings, "region = "+e.region)
+	}
+	if len(m.Output) > 0 {
+		settings = append(settings, "output = "+m.Output)
+	}
+	settings = append(settings, m.extraProfileLines()...)
+	return configProfileEntry{
+		profileName: e.profileName,
+		roleArn:     m.RoleArn,
+		description: m.getDescription(),
+		settings:    settings,
+	}
+}
+
+// header returns the section header which is '[profile name]' for
+// everything except the default profile. The name is cleaned up the
+// same way acfmgr cleans credentials file entries so they match.
+func (e *configProfileEntry) header() string {
+	name := strings.Replace(e.profileName, " ", "-", -1)
+	name = strings.Replace(name, "[", "", -1)
+	name = strings.Replace(name, "]", "", -1)
+	if name == "default" {
+		return "[default]"
+	}
+	return fmt.Sprintf("[profile %s]", name)
+}
+
+// lines returns the body of the section
+func (e *configProfileEntry) lines() (lines []string) {
+	lines = append(lines, managedSectionHeader...)
+	lines = append(lines, "# ASSUMED ROLE: "+e.roleArn)
+	if len(e.description) > 0 {
+		lines = append(lines, "# DESCRIPTION: "+e.description)
+	}
+	lines = append(lines, e.settings...)
+	// blank line between sections
+	return append(lines, "")
+}
+
+// configSection is a section of an existing config file. Anything before
+// the first section header is kept as a section with a blank header.
+type configSection struct {
+	header string
+	lines  []string
+}
+
+func (s *configSection) managed() bool {
+	for _, line := range s.lines {
+		if strings.TrimSpace(line) == managedSectionHeader[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConfigSections splits the contents of a config file into sections
+func parseConfigSections(data string) (sections []*configSection) {
+	current := &configSection{}
+	sections = append(sections, current)
+	if len(strings.TrimSpace(data)) < 1 {
+		return sections
+	}
+	for _, line := range strings.Split(strings.TrimRight(data, "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			current = &configSection{header: trimmed}
+			sections = append(sections, current)
+			continue
+		}
+		current.lines = append(current.lines, line)
+	}
+	return sections
+}
+
+// mergeConfigProfiles replaces gossamer's sections in the config file
+// contents with the given entries and adds the new ones at the end.
+// Sections without the managed header belong to the user and are never
+// touched even if their name matches.
+func mergeConfigProfiles(data string, entries []configProfileEntry) (merged string, written int) {
+	sections := parseConfigSections(data)
+	for i := range entries {
+		e := &entries[i]
+		header := e.header()
+		var existing *configSection
+		userOwned := false
+		for _, s := range sections {
+			if s.header != header {
+				continue
+			}
+			if !s.managed() {
+				userOwned = true
+				break
+			}
+			if existing == nil {
+				existing = s
+				continue
+			}
+			// drop managed duplicates
+			s.header = ""
+			s.lines = nil
+		}
+		switch {
+		case userOwned:
+			goslogger.Loggo.Warn("leaving user owned profile in config file alone", "profile", header)
+			continue
+		case existing != nil:
+			existing.lines = e.lines()
+		default:
+			sections = append(sections, &configSection{header: header, lines: e.lines()})
+		}
+		written++
+	}
+	var b strings.Builder
+	for _, s := range sections {
+		if len(s.header) > 0 {
+			b.WriteString(s.header + "\n")
+		}
+		for _, line := range s.lines {
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.String(), written
+}
+
+// expandHome replaces a leading '~' in the path with the user's home directory
+func expandHome(path string) (expanded string, err error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path, err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path, err
+	}
+	return filepath.Join(home, path[1:]), err
+}
+
+// WriteConfigFile writes a profile section to the config_file (e.g.,
+// '~/.aws/config') for every profile entry queued by the flows so tools
+// that only read settings like region from there still see them. It does
+// nothing if no config_file is set and returns how many were written.
+func (gc *Config) WriteConfigFile() (count int, err error) {
+	if len(gc.ConfigFile) < 1 {
+		return count, err
+	}
+	filename, err := expandHome(gc.ConfigFile)
+	if err != nil {
+		return count, err
+	}
+	if outFile, _ := expandHome(gc.OutFile); filepath.Clean(outFile) == filepath.Clean(filename) {
+		msg := fmt.Sprintf("config_file '%s' can't be the same as output_file", gc.ConfigFile)
+		err = errors.New(msg)
+		return count, err
+	}
+	var entries []configProfileEntry
+	for _, f := range gc.Flows {
+		entries = append(entries, f.configEntries...)
+	}
+	if len(entries) < 1 {
+		return count, err
+	}
+	// write through a symlink instead of replacing it
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = resolved
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return count, err
+	}
+	merged, count := mergeConfigProfiles(string(data), entries)
+	err = os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return count, err
+	}
+	err = writeFileAtomic(filename, []byte(merged))
+	if err != nil {
+		return count, err
+	}
+	goslogger.Loggo.Info("wrote profiles to config file", "filename", filename, "count", count)
+	return count, err
+}
+
+// writeFileAtomic writes the data to a temp file next to the filename and
+// renames it into place so a failed write never leaves a partial file.
+// An existing file keeps its mode and a new one is only readable by the user.
+func writeFileAtomic(filename string, data []byte) (err error) {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	_, err = tmp.Write(data)
+	if err != nil {
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), mode)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
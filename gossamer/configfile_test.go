@@ -0,0 +1,148 @@
+package gossamer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeConfigProfiles(t *testing.T) {
+	initLog()
+	managed := strings.Join(managedSectionHeader, "\n")
+	existing := "# my config\n" +
+		"[default]\nregion = eu-west-1\n\n" +
+		"[profile old]\n" + managed + "\n# ASSUMED ROLE: arn:aws:iam::123456789012:role/old\nregion = us-west-1\n\n" +
+		"[profile mine]\nregion = ap-south-1\n\n" +
+		"[profile old]\n" + managed + "\nregion = us-west-2\n"
+	cases := []struct {
+		data     string
+		entries  []configProfileEntry
+		written  int
+		expected string
+	}{
+		{
+			data: "",
+			entries: []configProfileEntry{
+				{profileName: "dev admin", roleArn: "arn:aws:iam::123456789012:role/admin", settings: []string{"region = us-east-1"}},
+			},
+			written: 1,
+			expected: "[profile dev-admin]\n" + managed +
+				"\n# ASSUMED ROLE: arn:aws:iam::123456789012:role/admin\nregion = us-east-1\n\n",
+		},
+		{
+			data: existing,
+			entries: []configProfileEntry{
+				{profileName: "old", roleArn: "arn:aws:iam::123456789012:role/old", description: "flow", settings: []string{"region = us-east-2", "output = json"}},
+				{profileName: "mine", roleArn: "arn:aws:iam::123456789012:role/mine", settings: []string{"region = us-east-2"}},
+				{profileName: "default", roleArn: "arn:aws:iam::123456789012:role/default"},
+			},
+			written: 1,
+			expected: "# my config\n" +
+				"[default]\nregion = eu-west-1\n\n" +
+				"[profile old]\n" + managed + "\n# ASSUMED ROLE: arn:aws:iam::123456789012:role/old\n# DESCRIPTION: flow\nregion = us-east-2\noutput = json\n\n" +
+				"[profile mine]\nregion = ap-south-1\n\n",
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		merged, written := mergeConfigProfiles(c.data, c.entries)
+		if written != c.written {
+			t.Errorf("expected %d written but got %d", c.written, written)
+		}
+		if merged != c.expected {
+			t.Errorf("unexpected config file:\nwant:\n%s\ngot:\n%s", c.expected, merged)
+		}
+	}
+}
+
+func TestWriteConfigFile(t *testing.T) {
+	initLog()
+	dir, err := ioutil.TempDir("", "gossamer-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := newTestChainFlow([]Mapping{{
+		RoleArn:     "arn:aws:iam::123456789012:role/viewer",
+		ProfileName: "viewer",
+		Regions:     []string{"us-east-1", "eu-west-1"},
+		Output:      "table",
+		Extra:       map[string]string{"s3.max_concurrent_requests": "20"},
+	}}, nil)
+	gc := f.parentConfig
+	gc.OutFile = filepath.Join(dir, "credentials")
+	for _, e := range mustProfileEntries(t, &f.PAss.Mappings[0]) {
+		f.configEntries = append(f.configEntries, f.PAss.Mappings[0].configProfileEntry(e))
+	}
+	// nothing happens without a config_file
+	if count, err := gc.WriteConfigFile(); count != 0 || err != nil {
+		t.Errorf("expected no-op but got %d, %v", count, err)
+	}
+	gc.ConfigFile = gc.OutFile
+	if _, err := gc.WriteConfigFile(); err == nil {
+		t.Error("expected error when config_file is the output_file")
+	}
+	gc.ConfigFile = filepath.Join(dir, "aws", "config")
+	count, err := gc.WriteConfigFile()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 profiles written but got %d", count)
+	}
+	assertFileMode(t, gc.ConfigFile, 0600)
+	data, err := ioutil.ReadFile(gc.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"[profile viewer-us-east-1]",
+		"[profile viewer-eu-west-1]",
+		"region = eu-west-1\noutput = table\ns3 =\n    max_concurrent_requests = 20\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected config file to contain %q but got:\n%s", want, data)
+		}
+	}
+	// writing again replaces the sections instead of adding more and
+	// keeps the mode of the existing file
+	if err := os.Chmod(gc.ConfigFile, 0640); err != nil {
+		t.Fatal(err)
+	}
+	_, err = gc.WriteConfigFile()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertFileMode(t, gc.ConfigFile, 0640)
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "aws", ".config.tmp*")); len(leftovers) > 0 {
+		t.Errorf("expected no temp files but found %v", leftovers)
+	}
+	again, err := ioutil.ReadFile(gc.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("expected rewrite to be stable but got:\n%s", again)
+	}
+}
+
+func mustProfileEntries(t *testing.T, m *Mapping) []profileEntry {
+	entries, err := m.profileEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func assertFileMode(t *testing.T, filename string, want os.FileMode) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != want {
+		t.Errorf("expected '%s' to have mode %v but got %v", filename, want, info.Mode().Perm())
+	}
+}
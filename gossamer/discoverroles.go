@@ -0,0 +1,470 @@
+package gossamer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// RoleDiscovery looks for roles in the sponsors' accounts (and any other
+// target accounts) that trust the sponsor and adds them as mappings after
+// the configured mappings have been assumed. Roles can be narrowed down by
+// path prefix, by tags and by include and exclude rules which work like
+// the ones for SAML roles. Other mapping settings (e.g., region) are
+// copied to the results.
+type RoleDiscovery struct {
+	// Sponsors are the mappings whose accounts are searched and whose
+	// credentials are used. Under primary assumptions it must be blank
+	// and the flow's starting credentials are used instead.
+	Sponsors            []string          `yaml:"sponsors,omitempty"`
+	PathPrefix          string            `yaml:"path_prefix,omitempty"`
+	RoleTags            map[string]string `yaml:"role_tags,omitempty"`
+	Include             []*RoleSelector   `yaml:"include,omitempty"`
+	Exclude             []*RoleSelector   `yaml:"exclude,omitempty"`
+	ProfileNameTemplate string            `yaml:"profile_name_template,omitempty"`
+	// Accounts are searched too by having the sponsor assume ListRoleName
+	// in each of them to list their roles
+	Accounts     []string `yaml:"accounts,omitempty"`
+	ListRoleName string   `yaml:"list_role_name,omitempty"`
+	// DryRun only logs the roles that would be assumed
+	DryRun  bool `yaml:"dry_run,omitempty"`
+	Mapping `yaml:",inline"`
+}
+
+// discoveredRole is a role found by discovery that trusts the sponsor
+type discoveredRole struct {
+	roleArn     string
+	conditional bool
+	rule        *RoleSelector
+}
+
+// discoverySponsor is the identity roles are discovered for
+type discoverySponsor struct {
+	ref       string // blank for the flow's starting credentials
+	principal string
+	client    iamiface.IAMAPI
+}
+
+// trustPolicy is the part of a role's trust policy we look at
+type trustPolicy struct {
+	Statement trustStatements
+}
+
+type trustStatement struct {
+	Effect    string
+	Action    interface{}
+	Principal interface{}
+	Condition map[string]interface{}
+}
+
+// trustStatements accepts a single statement or a list
+type trustStatements []trustStatement
+
+func (ts *trustStatements) UnmarshalJSON(data []byte) (err error) {
+	var list []trustStatement
+	if err = json.Unmarshal(data, &list); err == nil {
+		*ts = list
+		return err
+	}
+	var single trustStatement
+	err = json.Unmarshal(data, &single)
+	*ts = []trustStatement{single}
+	return err
+}
+
+// stringOrSlice flattens a policy value that can be a string or a list
+func stringOrSlice(v interface{}) (out []string) {
+	switch t := v.(type) {
+	case string:
+		out = append(out, t)
+	case []interface{}:
+		for _, s := range t {
+			if str, ok := s.(string); ok {
+				out = append(out, str)
+			}
+		}
+	}
+	return out
+}
+
+// allowsAssumeRole returns true if the statement's actions include sts:AssumeRole
+func (s trustStatement) allowsAssumeRole() bool {
+	for _, action := range stringOrSlice(s.Action) {
+		switch strings.ToLower(action) {
+		case "sts:assumerole", "sts:*", "*":
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPrincipal returns true if the statement's principal covers the
+// given ARN either directly, by its account or by a wildcard
+func (s trustStatement) matchesPrincipal(principalArn string) bool {
+	var entries []string
+	switch p := s.Principal.(type) {
+	case string:
+		entries = append(entries, p)
+	case map[string]interface{}:
+		entries = stringOrSlice(p["AWS"])
+	}
+	account := accountFromArn(principalArn)
+	partition := partitionFromArn(principalArn)
+	if len(partition) < 1 {
+		partition = "aws"
+	}
+	// trust policies name the role behind an assumed role session
+	// (with its path) rather than the session itself
+	roleName := ""
+	if strings.Contains(principalArn, ":assumed-role/") {
+		if parts := strings.Split(principalArn, "/"); len(parts) > 2 {
+			roleName = parts[1]
+		}
+	}
+	for _, e := range entries {
+		switch e {
+		case "*", principalArn, account, fmt.Sprintf("arn:%s:iam::%s:root", partition, account):
+			return true
+		}
+		if len(roleName) > 0 && strings.HasPrefix(e, fmt.Sprintf("arn:%s:iam::%s:role/", partition, account)) && strings.HasSuffix(e, "/"+roleName) {
+			return true
+		}
+	}
+	return false
+}
+
+// trusts checks whether the URL encoded trust policy lets the principal
+// assume the role. Conditions can't be evaluated here so roles that
+// only trust the principal with conditions are reported as conditional.
+func trusts(document, principalArn string) (ok, conditional bool, err error) {
+	decoded, err := url.QueryUnescape(document)
+	if err != nil {
+		return ok, conditional, err
+	}
+	var policy trustPolicy
+	err = json.Unmarshal([]byte(decoded), &policy)
+	if err != nil {
+		return ok, conditional, err
+	}
+	conditional = true
+	for _, s := range policy.Statement {
+		if !s.allowsAssumeRole() || !s.matchesPrincipal(principalArn) {
+			continue
+		}
+		if strings.EqualFold(s.Effect, "Deny") {
+			if len(s.Condition) < 1 {
+				return false, false, err
+			}
+			continue
+		}
+		ok = true
+		if len(s.Condition) < 1 {
+			conditional = false
+		}
+	}
+	return ok, ok && conditional, err
+}
+
+// validate checks the discovery settings before anything runs
+func (d *RoleDiscovery) validate(a *Assumptions) (err error) {
+	if d == nil {
+		return err
+	}
+	primary := a.parentFlow != nil && a == a.parentFlow.PAss
+	if primary && a.parentFlow.SAMLConfig != nil {
+		err = errors.New("discover_roles isn't supported on SAML primary assumptions, use it under secondary_assumptions instead")
+		return err
+	}
+	if primary && len(d.Sponsors) > 0 {
+		err = errors.New("discover_roles sponsors can only be used under secondary_assumptions")
+		return err
+	}
+	if len(d.RoleArn) > 0 || len(d.ProfileName) > 0 || len(d.sponsorRef()) > 0 {
+		err = errors.New("discover_roles can't set role_arn, profile_name or sponsor (use sponsors and profile_name_template instead)")
+		return err
+	}
+	if len(d.ProfileNameTemplate) > 0 {
+		_, err = parseProfileNameTemplate(d.ProfileNameTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	if len(d.Accounts) > 0 && len(d.ListRoleName) < 1 {
+		err = errors.New("discover_roles accounts need a list_role_name to assume in each account to list its roles")
+		return err
+	}
+	for _, account := range d.Accounts {
+		if !accountNumberRegex.MatchString(account) {
+			msg := fmt.Sprintf("discover_roles has invalid account number '%s'", account)
+			err = errors.New(msg)
+			return err
+		}
+	}
+	return d.selectors().validateSelectors()
+}
+
+// accountSponsors returns the sponsor once for every target account other
+// than its own with an IAM client for that account. The client's
+// credentials come from the sponsor assuming the list role there.
+// Accounts where that fails are logged and skipped.
+func (a *Assumptions) accountSponsors(s discoverySponsor, client stsiface.STSAPI) (sponsors []discoverySponsor) {
+	d := a.DiscoverRoles
+	sessionName := aws.StringValue(a.getRoleSessionName())
+	if len(sessionName) < 1 {
+		sessionName = "gossamer"
+	}
+	partition := partitionFromArn(s.principal)
+	for _, account := range d.Accounts {
+		if account == accountFromArn(s.principal) {
+			continue
+		}
+		listRoleArn := fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, account, strings.Trim(d.ListRoleName, "/"))
+		cred, err := assumeRoleWithClient(aws.String(listRoleArn), aws.String(sessionName), aws.Int64(0), nil, client)
+		if err != nil {
+			goslogger.Loggo.Error("unable to assume role to list roles in account", "flow", a.parentFlow.Name, "roleArn", listRoleArn, "error", err)
+			continue
+		}
+		sess, err := session.NewSessionWithOptions(session.Options{
+			Config: aws.Config{Credentials: convertSCredsToCreds(cred)},
+		})
+		if err != nil {
+			goslogger.Loggo.Error("unable to create session to list roles in account", "flow", a.parentFlow.Name, "account", account, "error", err)
+			continue
+		}
+		sponsors = append(sponsors, discoverySponsor{ref: s.ref, principal: s.principal, client: iam.New(sess)})
+	}
+	return sponsors
+}
+
+// selectors returns the include and exclude rules as assumptions
+// so the usual selection logic can be used
+func (d *RoleDiscovery) selectors() *Assumptions {
+	return &Assumptions{AllRoles: true, Include: d.Include, Exclude: d.Exclude}
+}
+
+// findRoles lists the roles in the client's account and returns the ones
+// picked by the filters that trust the principal
+func (d *RoleDiscovery) findRoles(client iamiface.IAMAPI, principalArn string) (found []discoveredRole, err error) {
+	var roles []*iam.Role
+	input := iam.ListRolesInput{}
+	if len(d.PathPrefix) > 0 {
+		input.PathPrefix = aws.String(d.PathPrefix)
+	}
+	err = client.ListRolesPages(&input, func(out *iam.ListRolesOutput, lastPage bool) bool {
+		roles = append(roles, out.Roles...)
+		return true
+	})
+	if err != nil {
+		return found, err
+	}
+	sel := d.selectors()
+	for _, role := range roles {
+		roleArn := aws.StringValue(role.Arn)
+		if roleArn == principalArn {
+			continue
+		}
+		selected, rule := sel.selectRole(roleArn)
+		if !selected {
+			continue
+		}
+		if len(d.RoleTags) > 0 {
+			ok, err := d.matchesTags(client, aws.StringValue(role.RoleName))
+			if err != nil {
+				return found, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		ok, conditional, err := trusts(aws.StringValue(role.AssumeRolePolicyDocument), principalArn)
+		if err != nil {
+			goslogger.Loggo.Debug("unable to read trust policy", "roleArn", roleArn, "error", err)
+			continue
+		}
+		if !ok {
+			goslogger.Loggo.Debug("skipping discovered role that doesn't trust sponsor", "roleArn", roleArn, "sponsor", principalArn)
+			continue
+		}
+		found = append(found, discoveredRole{roleArn: roleArn, conditional: conditional, rule: rule})
+	}
+	return found, err
+}
+
+// matchesTags returns true if the role has every tag in RoleTags
+func (d *RoleDiscovery) matchesTags(client iamiface.IAMAPI, roleName string) (ok bool, err error) {
+	tags := make(map[string]string)
+	input := iam.ListRoleTagsInput{RoleName: aws.String(roleName)}
+	for {
+		out, err := client.ListRoleTags(&input)
+		if err != nil {
+			return ok, err
+		}
+		for _, tag := range out.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		if !aws.BoolValue(out.IsTruncated) {
+			break
+		}
+		input.Marker = out.Marker
+	}
+	for k, v := range d.RoleTags {
+		if got, found := tags[k]; !found || got != v {
+			return false, err
+		}
+	}
+	return true, err
+}
+
+// newDiscoveredMappings turns the roles found for a sponsor into mappings.
+// Roles that are already mapped in the flow are left out.
+func (a *Assumptions) newDiscoveredMappings(found []discoveredRole, s discoverySponsor) (mappings []Mapping, err error) {
+	d := a.DiscoverRoles
+	for _, role := range found {
+		if existing := a.parentFlow.findMappingByRef(role.roleArn); existing != nil {
+			continue
+		}
+		m := d.Mapping
+		m.RoleArn = role.roleArn
+		m.Sponsor = s.ref
+		m.setRelationships(a, a.parentFlow, a.parentConfig)
+		if role.rule != nil {
+			err = role.rule.apply(&m, a.parentFlow.Name)
+			if err != nil {
+				return mappings, err
+			}
+		}
+		if len(m.ProfileName) < 1 && len(d.ProfileNameTemplate) > 0 {
+			m.ProfileName, err = m.renderProfileName(d.ProfileNameTemplate)
+			if err != nil {
+				return mappings, err
+			}
+		}
+		err = m.loadSessionPolicy()
+		if err != nil {
+			return mappings, err
+		}
+		switch {
+		case d.DryRun:
+			goslogger.Loggo.Info("dry run: would assume discovered role",
+				"flow", a.parentFlow.Name,
+				"roleArn", m.RoleArn,
+				"profileName", m.mappingID(),
+				"sponsor", s.principal,
+				"conditionalTrust", role.conditional,
+			)
+		case role.conditional:
+			goslogger.Loggo.Info("discovered role only trusts sponsor with conditions so it may fail", "roleArn", m.RoleArn)
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, err
+}
+
+// addDiscoveredRoles lists the roles for each sponsor and either logs them
+// (dry run) or appends them to the assumptions. It returns the index of
+// the first new mapping.
+func (a *Assumptions) addDiscoveredRoles(sponsors []discoverySponsor) (start int, err error) {
+	d := a.DiscoverRoles
+	start = len(a.Mappings)
+	var added []Mapping
+	for _, s := range sponsors {
+		found, err := d.findRoles(s.client, s.principal)
+		if err != nil {
+			goslogger.Loggo.Error("unable to discover roles", "flow", a.parentFlow.Name, "sponsor", s.principal, "error", err)
+			continue
+		}
+		mappings, err := a.newDiscoveredMappings(found, s)
+		if err != nil {
+			return start, err
+		}
+		added = append(added, mappings...)
+	}
+	goslogger.Loggo.Info("discovered roles", "flow", a.parentFlow.Name, "count", len(added), "dryRun", d.DryRun)
+	if d.DryRun || len(added) < 1 {
+		return start, err
+	}
+	return start, a.addDiscoveredMappings(added)
+}
+
+// discoverPrimaryRoles finds roles trusting the flow's starting
+// credentials and assumes them alongside the primary mappings
+func (f *Flow) discoverPrimaryRoles() (err error) {
+	if f.PAss == nil || f.PAss.DiscoverRoles == nil {
+		return err
+	}
+	sess, err := f.getPermSession()
+	if err != nil {
+		return err
+	}
+	sponsors := []discoverySponsor{{principal: f.callerArn, client: iam.New(sess)}}
+	sponsors = append(sponsors, f.PAss.accountSponsors(sponsors[0], sts.New(sess))...)
+	start, err := f.PAss.addDiscoveredRoles(sponsors)
+	if err != nil || start == len(f.PAss.Mappings) {
+		return err
+	}
+	f.markSelection()
+	f.PAss.assumeMappingsFrom(start)
+	return err
+}
+
+// discoverSecondaryRoles finds roles trusting each of the sponsors once
+// they've been assumed and assumes them as secondary mappings
+func (f *Flow) discoverSecondaryRoles() (err error) {
+	if f.SAss == nil || f.SAss.DiscoverRoles == nil {
+		return err
+	}
+	refs := f.SAss.DiscoverRoles.Sponsors
+	if len(refs) < 1 && f.PAss != nil && len(f.PAss.Mappings) == 1 {
+		refs = []string{f.PAss.Mappings[0].mappingID()}
+	}
+	if len(refs) < 1 {
+		err = errors.New("discover_roles needs sponsors when there's more than one primary mapping")
+		return err
+	}
+	var sponsors []discoverySponsor
+	for _, ref := range refs {
+		sponsor := f.findMappingByRef(ref)
+		if sponsor == nil {
+			goslogger.Loggo.Error("discover_roles sponsor not found", "flow", f.Name, "sponsor", ref)
+			continue
+		}
+		cred, err := sponsor.getCredential()
+		if err != nil {
+			goslogger.Loggo.Error("discover_roles sponsor has no credentials", "flow", f.Name, "sponsor", ref, "error", err)
+			continue
+		}
+		sess, err := session.NewSessionWithOptions(session.Options{
+			Config: aws.Config{Credentials: convertSCredsToCreds(cred)},
+		})
+		if err != nil {
+			return err
+		}
+		s := discoverySponsor{ref: ref, principal: sponsor.RoleArn, client: iam.New(sess)}
+		sponsors = append(sponsors, s)
+		sponsors = append(sponsors, f.SAss.accountSponsors(s, sts.New(sess))...)
+	}
+	start, err := f.SAss.addDiscoveredRoles(sponsors)
+	if err != nil || start == len(f.SAss.Mappings) {
+		return err
+	}
+	err = f.resolveSponsors(true)
+	if err != nil {
+		goslogger.Loggo.Error("some discovered roles will not be assumed", "error", err)
+		err = nil
+	}
+	f.markSelection()
+	if !f.SAss.Lazy {
+		f.SAss.assumeMappingsFrom(start)
+	}
+	return err
+}
@@ -0,0 +1,285 @@
+package gossamer
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func (m *mockIAMClient) ListRolesPages(input *iam.ListRolesInput, fn func(*iam.ListRolesOutput, bool) bool) (err error) {
+	m.calls++
+	if m.SVCErr != nil {
+		return m.SVCErr
+	}
+	// one role per page to exercise paging
+	for i, role := range m.roles {
+		if !strings.HasPrefix(aws.StringValue(role.Path), aws.StringValue(input.PathPrefix)) {
+			continue
+		}
+		if !fn(&iam.ListRolesOutput{Roles: []*iam.Role{role}}, i == len(m.roles)-1) {
+			break
+		}
+	}
+	return err
+}
+
+func (m *mockIAMClient) ListRoleTags(input *iam.ListRoleTagsInput) (output *iam.ListRoleTagsOutput, err error) {
+	m.calls++
+	output = &iam.ListRoleTagsOutput{IsTruncated: aws.Bool(false)}
+	for k, v := range m.roleTags[aws.StringValue(input.RoleName)] {
+		output.Tags = append(output.Tags, &iam.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return output, err
+}
+
+// newTestRole returns a role whose trust policy has the given statements
+func newTestRole(account, path, name, statements string) *iam.Role {
+	doc := fmt.Sprintf(`{"Version":"2012-10-17","Statement":%s}`, statements)
+	return &iam.Role{
+		Arn:                      aws.String(fmt.Sprintf("arn:aws:iam::%s:role%s%s", account, path, name)),
+		Path:                     aws.String(path),
+		RoleName:                 aws.String(name),
+		AssumeRolePolicyDocument: aws.String(url.QueryEscape(doc)),
+	}
+}
+
+func TestTrusts(t *testing.T) {
+	initLog()
+	sponsor := "arn:aws:iam::111111111111:role/hub"
+	cases := []struct {
+		statements  string
+		principal   string
+		ok          bool
+		conditional bool
+	}{
+		{
+			statements: `[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111111111111:role/hub"},"Action":"sts:AssumeRole"}]`,
+			ok:         true,
+		},
+		{
+			statements: `{"Effect":"Allow","Principal":{"AWS":["arn:aws:iam::999999999999:root","111111111111"]},"Action":["sts:TagSession","sts:AssumeRole"]}`,
+			ok:         true,
+		},
+		{
+			statements: `[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111111111111:root"},"Action":"sts:AssumeRole"}]`,
+			ok:         true,
+		},
+		{
+			statements:  `[{"Effect":"Allow","Principal":"*","Action":"sts:AssumeRole","Condition":{"StringEquals":{"aws:PrincipalOrgID":"o-123"}}}]`,
+			ok:          true,
+			conditional: true,
+		},
+		{
+			statements: `[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111111111111:role/ops/hub"},"Action":"sts:AssumeRole"}]`,
+			principal:  "arn:aws:sts::111111111111:assumed-role/hub/session",
+			ok:         true,
+		},
+		{
+			statements: `[{"Effect":"Allow","Principal":{"AWS":"arn:aws-us-gov:iam::111111111111:root"},"Action":"sts:AssumeRole"}]`,
+			principal:  "arn:aws-us-gov:iam::111111111111:role/hub",
+			ok:         true,
+		},
+		{
+			statements: `[{"Effect":"Allow","Principal":{"AWS":"arn:aws-cn:iam::111111111111:role/ops/hub"},"Action":"sts:AssumeRole"}]`,
+			principal:  "arn:aws-cn:sts::111111111111:assumed-role/hub/session",
+			ok:         true,
+		},
+		{
+			statements: `[{"Effect":"Allow","Principal":{"Service":"ec2.amazonaws.com"},"Action":"sts:AssumeRole"}]`,
+		},
+		{
+			statements: `[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111111111111:role/hub"},"Action":"sts:AssumeRoleWithSAML"}]`,
+		},
+		{
+			statements: `[{"Effect":"Allow","Principal":{"AWS":"111111111111"},"Action":"sts:AssumeRole"},` +
+				`{"Effect":"Deny","Principal":{"AWS":"arn:aws:iam::111111111111:role/hub"},"Action":"sts:*"}]`,
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		principal := sponsor
+		if len(c.principal) > 0 {
+			principal = c.principal
+		}
+		role := newTestRole("222222222222", "/", "target", c.statements)
+		ok, conditional, err := trusts(aws.StringValue(role.AssumeRolePolicyDocument), principal)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if ok != c.ok || conditional != c.conditional {
+			t.Errorf("expected ok=%t conditional=%t but got ok=%t conditional=%t", c.ok, c.conditional, ok, conditional)
+		}
+	}
+	if _, _, err := trusts("%7Bnot json", sponsor); err == nil {
+		t.Error("expected error for bad trust policy")
+	}
+}
+
+func TestAddDiscoveredRoles(t *testing.T) {
+	initLog()
+	hub := "arn:aws:iam::111111111111:role/hub"
+	trustHub := `[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111111111111:role/hub"},"Action":"sts:AssumeRole"}]`
+	trustOther := `[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::999999999999:root"},"Action":"sts:AssumeRole"}]`
+	client := &mockIAMClient{
+		roles: []*iam.Role{
+			newTestRole("111111111111", "/", "hub", trustHub),
+			newTestRole("111111111111", "/", "admin", trustHub),
+			newTestRole("111111111111", "/ops/", "readonly", trustHub),
+			newTestRole("111111111111", "/ops/", "deploy", trustHub),
+			newTestRole("111111111111", "/", "mapped", trustHub),
+			newTestRole("111111111111", "/", "stranger", trustOther),
+		},
+		roleTags: map[string]map[string]string{
+			"admin":    {"gossamer": "yes"},
+			"readonly": {"gossamer": "yes"},
+			"deploy":   {"gossamer": "no"},
+		},
+	}
+	cases := []struct {
+		discovery RoleDiscovery
+		expected  []string
+	}{
+		{
+			discovery: RoleDiscovery{},
+			expected: []string{
+				"arn:aws:iam::111111111111:role/admin=111111111111_admin",
+				"arn:aws:iam::111111111111:role/ops/readonly=111111111111_ops/readonly",
+				"arn:aws:iam::111111111111:role/ops/deploy=111111111111_ops/deploy",
+			},
+		},
+		{
+			discovery: RoleDiscovery{
+				PathPrefix:          "/ops/",
+				RoleTags:            map[string]string{"gossamer": "yes"},
+				ProfileNameTemplate: "hub-{{.RoleName}}",
+			},
+			expected: []string{"arn:aws:iam::111111111111:role/ops/readonly=hub-readonly"},
+		},
+		{
+			discovery: RoleDiscovery{
+				Include: []*RoleSelector{{RoleName: "admin", ProfileNameTemplate: "boss"}, {RolePath: "/ops/"}},
+				Exclude: []*RoleSelector{{RoleName: "deploy"}},
+			},
+			expected: []string{
+				"arn:aws:iam::111111111111:role/admin=boss",
+				"arn:aws:iam::111111111111:role/ops/readonly=111111111111_ops/readonly",
+			},
+		},
+		{
+			discovery: RoleDiscovery{DryRun: true},
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := newTestChainFlow(
+			[]Mapping{{RoleArn: hub, ProfileName: "hub"}},
+			[]Mapping{{RoleArn: "arn:aws:iam::111111111111:role/mapped"}},
+		)
+		d := c.discovery
+		f.SAss.DiscoverRoles = &d
+		err := d.validate(f.SAss)
+		if err != nil {
+			t.Errorf("unexpected validation error: %s", err)
+			continue
+		}
+		start, err := f.SAss.addDiscoveredRoles([]discoverySponsor{{ref: "hub", principal: hub, client: client}})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if start != 1 {
+			t.Errorf("expected new mappings to start at 1 but got %d", start)
+		}
+		var got []string
+		for _, m := range f.SAss.Mappings[start:] {
+			got = append(got, m.RoleArn+"="+m.mappingID())
+			if m.Sponsor != "hub" || m.parentAssumptions != f.SAss {
+				t.Errorf("expected discovered mapping to have sponsor and relationships: %+v", m)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("unexpected mappings:\nwant %v\ngot  %v", c.expected, got)
+		}
+	}
+}
+
+func TestRoleDiscoveryValidate(t *testing.T) {
+	initLog()
+	f := newTestChainFlow(nil, nil)
+	cases := []struct {
+		discovery   *RoleDiscovery
+		primary     bool
+		errContains string
+	}{
+		{discovery: nil},
+		{discovery: &RoleDiscovery{Sponsors: []string{"hub"}}},
+		{discovery: &RoleDiscovery{}, primary: true},
+		{discovery: &RoleDiscovery{Sponsors: []string{"hub"}}, primary: true, errContains: "sponsors can only be used"},
+		{discovery: &RoleDiscovery{Mapping: Mapping{RoleArn: "arn:aws:iam::111111111111:role/x"}}, errContains: "can't set role_arn"},
+		{discovery: &RoleDiscovery{ProfileNameTemplate: "{{.Oops"}, errContains: "error parsing"},
+		{discovery: &RoleDiscovery{Include: []*RoleSelector{{}}}, errContains: "must set at least one"},
+		{discovery: &RoleDiscovery{Accounts: []string{"222222222222"}, ListRoleName: "ops/reader"}},
+		{discovery: &RoleDiscovery{Accounts: []string{"222222222222"}}, errContains: "need a list_role_name"},
+		{discovery: &RoleDiscovery{Accounts: []string{"2222"}, ListRoleName: "reader"}, errContains: "invalid account number"},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		a := f.SAss
+		if c.primary {
+			a = f.PAss
+		}
+		err := c.discovery.validate(a)
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("expected error containing '%s' but got '%v'", c.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+	f.SAMLConfig = &SAMLConfig{}
+	if err := (&RoleDiscovery{}).validate(f.PAss); err == nil {
+		t.Error("expected error for discovery on SAML primary assumptions")
+	}
+}
+
+func TestAccountSponsors(t *testing.T) {
+	initLog()
+	f := newTestChainFlow(nil, nil)
+	f.SAss.DiscoverRoles = &RoleDiscovery{
+		Accounts:     []string{"111111111111", "222222222222", "333333333333"},
+		ListRoleName: "/ops/reader",
+	}
+	f.SAss.setRoleSessionName("alice")
+	client := &mockSTSClient{}
+	s := discoverySponsor{ref: "hub", principal: "arn:aws-us-gov:iam::111111111111:role/hub"}
+	sponsors := f.SAss.accountSponsors(s, client)
+	// the sponsor's own account is already searched with its own creds
+	expected := []string{
+		"arn:aws-us-gov:iam::222222222222:role/ops/reader",
+		"arn:aws-us-gov:iam::333333333333:role/ops/reader",
+	}
+	if strings.Join(client.assumed, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected list roles assumed:\nwant %v\ngot  %v", expected, client.assumed)
+	}
+	if len(sponsors) != len(expected) {
+		t.Fatalf("expected %d sponsors but got %d", len(expected), len(sponsors))
+	}
+	for _, got := range sponsors {
+		if got.ref != s.ref || got.principal != s.principal || got.client == nil {
+			t.Errorf("expected sponsor to keep its identity with a new client: %+v", got)
+		}
+	}
+	client = &mockSTSClient{SVCErr: errors.New("AccessDenied")}
+	if sponsors := f.SAss.accountSponsors(s, client); len(sponsors) > 0 {
+		t.Errorf("expected accounts that can't be listed to be skipped but got %d", len(sponsors))
+	}
+}
@@ -0,0 +1,256 @@
+package gossamer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+const (
+	// durationMax asks for the longest duration the role allows
+	durationMax = "max"
+	// minSessionDuration and maxSessionDuration are the range STS accepts
+	minSessionDuration int64 = 900
+	maxSessionDuration int64 = 43200
+	// maxChainedSessionDuration is the AWS limit for role chaining
+	maxChainedSessionDuration int64 = 3600
+	// defaultSessionDuration is what STS gives when no duration is asked for
+	defaultSessionDuration int64 = 3600
+	// maxSessionDurationCache holds each role's MaxSessionDuration
+	maxSessionDurationCache    = "max_session_durations"
+	maxSessionDurationCacheTTL = 7 * 24 * time.Hour
+)
+
+// validateDurationSetting checks the duration option
+func validateDurationSetting(label, duration string) (err error) {
+	if len(duration) > 0 && duration != durationMax {
+		msg := fmt.Sprintf("duration for %s must be '%s' or left blank (use session_duration_seconds for a number) but got '%s'", label, durationMax, duration)
+		err = errors.New(msg)
+	}
+	return err
+}
+
+// validateDurationSettings checks the duration option on the flow and all mappings
+func (f *Flow) validateDurationSettings() (err error) {
+	err = validateDurationSetting(fmt.Sprintf("flow '%s'", f.Name), f.Duration)
+	if err != nil {
+		return err
+	}
+	for _, a := range []*Assumptions{f.PAss, f.SAss} {
+		if a == nil {
+			continue
+		}
+		for _, m := range a.Mappings {
+			err = validateDurationSetting(fmt.Sprintf("mapping '%s'", m.RoleArn), m.Duration)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// durationLabel names the mapping in duration warnings and errors
+func (m *Mapping) durationLabel() string {
+	if len(m.ProfileName) > 0 {
+		return fmt.Sprintf("'%s' (%s)", m.ProfileName, m.RoleArn)
+	}
+	return fmt.Sprintf("'%s'", m.RoleArn)
+}
+
+// validateDurations checks the duration every configured mapping will
+// end up asking for using the same inheritance as Mapping.validate.
+// Durations STS will never accept are errors while ones that will be
+// cut short by the role chaining limit are warnings.
+func (f *Flow) validateDurations() (err error) {
+	var problems []string
+	if f.DurationSeconds < minSessionDuration || f.DurationSeconds > maxSessionDuration {
+		problems = append(problems, fmt.Sprintf("session_duration_seconds %d on the flow is outside the allowed %d-%d",
+			f.DurationSeconds, minSessionDuration, maxSessionDuration))
+	}
+	samlIgnoresMappings := f.SAMLConfig != nil && !f.SAMLConfig.AllowMappingDurationOverride
+	for _, a := range []*Assumptions{f.PAss, f.SAss} {
+		if a == nil {
+			continue
+		}
+		for _, m := range a.Mappings {
+			if m.Duration == durationMax || (m.DurationSeconds == 0 && f.Duration == durationMax) {
+				continue
+			}
+			if a.atype == "primary" && samlIgnoresMappings {
+				if m.DurationSeconds != 0 {
+					goslogger.Loggo.Warn("session_duration_seconds on mapping is ignored since the SAML flow doesn't set allow_mapping_duration_override",
+						"flow", f.Name, "mapping", m.durationLabel())
+				}
+				continue
+			}
+			duration := m.DurationSeconds
+			if duration == 0 {
+				duration = f.DurationSeconds
+			}
+			if duration < minSessionDuration || duration > maxSessionDuration {
+				problems = append(problems, fmt.Sprintf("mapping %s has a session duration of %d which is outside the allowed %d-%d",
+					m.durationLabel(), duration, minSessionDuration, maxSessionDuration))
+				continue
+			}
+			chained := a.atype == "secondary" || f.FromFlowConfig != nil
+			if chained && duration > maxChainedSessionDuration {
+				goslogger.Loggo.Warn("mapping is assumed by role chaining which AWS limits to 1 hour so it will get the default duration instead",
+					"flow", f.Name, "mapping", m.durationLabel(), "duration", duration, "max", maxChainedSessionDuration)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		err = fmt.Errorf("flow '%s' has invalid durations: %s", f.Name, strings.Join(problems, "; "))
+	}
+	return err
+}
+
+// wantsMaxDuration returns true if the mapping asked for the longest
+// duration allowed either directly or by inheriting it from the flow.
+// A session_duration_seconds on the mapping beats the flow's setting.
+func (m *Mapping) wantsMaxDuration() bool {
+	if m.Duration == durationMax {
+		return true
+	}
+	return !m.explicitDuration && m.parentFlow != nil && m.parentFlow.Duration == durationMax
+}
+
+// isChained returns true if the mapping will be assumed using another
+// role's credentials which limits it to the role chaining maximum
+func (m *Mapping) isChained() bool {
+	if m.parentSAMLConfig != nil {
+		return false
+	}
+	if m.parentAssumptions != nil && m.parentAssumptions.atype == "secondary" {
+		return true
+	}
+	return m.parentFlow != nil && m.parentFlow.FromFlowConfig != nil
+}
+
+// resolveMaxDuration works out the longest duration the mapping can ask
+// for. Chained roles are capped at an hour. Otherwise the role's
+// MaxSessionDuration comes from the cache or from iam:GetRole using the
+// given client (if any) which only works when the client is in the role's
+// account. When neither works the default is requested with a warning and
+// the role's own credentials are used to learn the max for the next run.
+func (m *Mapping) resolveMaxDuration(client iamiface.IAMAPI) (duration int64) {
+	if m.isChained() {
+		goslogger.Loggo.Info("capping max duration for chained role", "roleArn", m.RoleArn, "duration", maxChainedSessionDuration)
+		return maxChainedSessionDuration
+	}
+	cache := m.parentConfig.getCache(maxSessionDurationCache)
+	if val, ok := cache.get(m.RoleArn, time.Now()); ok {
+		duration, err := strconv.ParseInt(val, 10, 64)
+		if err == nil {
+			goslogger.Loggo.Debug("using cached max session duration", "roleArn", m.RoleArn, "duration", duration)
+			return duration
+		}
+	}
+	callerAccount := ""
+	if m.parentFlow != nil {
+		callerAccount = accountFromArn(m.parentFlow.callerArn)
+	}
+	switch {
+	case client == nil:
+	case len(callerAccount) < 1 || callerAccount != accountFromArn(m.RoleArn):
+		// GetRole only looks in the caller's own account
+		goslogger.Loggo.Debug("skipping max session duration lookup for role in another account", "roleArn", m.RoleArn, "callerAccount", callerAccount)
+	default:
+		duration, err := getRoleMaxSessionDuration(client, m.RoleArn)
+		if err == nil {
+			m.cacheMaxDuration(duration)
+			return duration
+		}
+		goslogger.Loggo.Debug("unable to look up max session duration with sponsor", "roleArn", m.RoleArn, "error", err)
+	}
+	m.maxDurationUnknown = true
+	goslogger.Loggo.Warn("max session duration unknown so requesting the default, it will be looked up with the role's own credentials for the next run",
+		"roleArn", m.RoleArn, "duration", defaultSessionDuration)
+	return defaultSessionDuration
+}
+
+// accountFromArn returns the account number field of an ARN
+func accountFromArn(arn string) string {
+	if fields := strings.Split(arn, ":"); len(fields) > 4 {
+		return fields[4]
+	}
+	return ""
+}
+
+// learnMaxDuration uses the mapping's own credentials to look up its
+// MaxSessionDuration so that the next run can ask for it directly. It's
+// best effort since roles often can't read themselves.
+func (m *Mapping) learnMaxDuration() {
+	if !m.maxDurationUnknown || m.credential == nil {
+		return
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{Credentials: convertSCredsToCreds(m.credential)},
+	})
+	if err != nil {
+		return
+	}
+	duration, err := getRoleMaxSessionDuration(iam.New(sess), m.RoleArn)
+	if err != nil {
+		goslogger.Loggo.Debug("unable to look up max session duration with role's own creds", "roleArn", m.RoleArn, "error", err)
+		return
+	}
+	m.cacheMaxDuration(duration)
+}
+
+func (m *Mapping) cacheMaxDuration(duration int64) {
+	goslogger.Loggo.Debug("caching max session duration", "roleArn", m.RoleArn, "duration", duration)
+	cache := m.parentConfig.getCache(maxSessionDurationCache)
+	err := cache.set(m.RoleArn, strconv.FormatInt(duration, 10), maxSessionDurationCacheTTL)
+	if err != nil {
+		goslogger.Loggo.Debug("unable to write max session duration cache", "error", err)
+	}
+}
+
+// getRoleMaxSessionDuration calls iam:GetRole for the role's MaxSessionDuration
+func getRoleMaxSessionDuration(client iamiface.IAMAPI, roleArn string) (duration int64, err error) {
+	rolename, _, err := parseRoleArn(roleArn)
+	if err != nil {
+		return duration, err
+	}
+	// GetRole wants the name without the path
+	_, name := splitRolePath(*rolename)
+	out, err := client.GetRole(&iam.GetRoleInput{RoleName: aws.String(name)})
+	if err != nil {
+		return duration, err
+	}
+	if out.Role == nil || out.Role.MaxSessionDuration == nil {
+		err = errors.New("GetRole response has no MaxSessionDuration")
+		return duration, err
+	}
+	// a role with the same name in another account isn't the one we want
+	if aws.StringValue(out.Role.Arn) != roleArn {
+		msg := fmt.Sprintf("GetRole returned '%s' instead of '%s'", aws.StringValue(out.Role.Arn), roleArn)
+		err = errors.New(msg)
+		return duration, err
+	}
+	return *out.Role.MaxSessionDuration, err
+}
+
+// logGrantedDuration reports how long the credentials we got are good
+// for next to what was asked for since STS may give us less
+func (m *Mapping) logGrantedDuration() {
+	if m.credential == nil || m.credential.Expiration == nil {
+		return
+	}
+	granted := time.Until(*m.credential.Expiration).Round(time.Minute)
+	goslogger.Loggo.Info("assumed role",
+		"roleArn", m.RoleArn,
+		"requestedSeconds", m.DurationSeconds,
+		"granted", granted.String(),
+	)
+}
@@ -0,0 +1,234 @@
+package gossamer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+type mockIAMClient struct {
+	iamiface.IAMAPI
+	maxSessionDuration int64
+	roleArn            string
+	accountAliases     []string
+	roles              []*iam.Role
+	roleTags           map[string]map[string]string
+	SVCErr             error
+	calls              int
+}
+
+func (m *mockIAMClient) GetRole(input *iam.GetRoleInput) (output *iam.GetRoleOutput, err error) {
+	m.calls++
+	if m.SVCErr != nil {
+		return output, m.SVCErr
+	}
+	output = &iam.GetRoleOutput{Role: &iam.Role{
+		RoleName:           input.RoleName,
+		Arn:                aws.String(m.roleArn),
+		MaxSessionDuration: aws.Int64(m.maxSessionDuration),
+	}}
+	return output, err
+}
+
+func TestResolveMaxDuration(t *testing.T) {
+	initLog()
+	dir, err := ioutil.TempDir("", "gossamer-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gc := &Config{CacheDir: dir}
+	cases := []struct {
+		roleArn   string
+		callerArn string
+		atype     string
+		fromFlow  bool
+		client    *mockIAMClient
+		result    int64
+		unknown   bool
+		wantCalls int
+	}{
+		{
+			roleArn: "arn:aws:iam::123456789012:role/chained",
+			atype:   "secondary",
+			client:  &mockIAMClient{maxSessionDuration: 14400},
+			result:  maxChainedSessionDuration,
+		},
+		{
+			roleArn:  "arn:aws:iam::123456789012:role/from-flow",
+			atype:    "primary",
+			fromFlow: true,
+			result:   maxChainedSessionDuration,
+		},
+		{
+			// GetRole would look in the caller's account instead
+			roleArn:   "arn:aws:iam::123456789012:role/path/four-hours",
+			callerArn: "arn:aws:iam::999999999999:user/me",
+			atype:     "primary",
+			client:    &mockIAMClient{maxSessionDuration: 14400, roleArn: "arn:aws:iam::999999999999:role/four-hours"},
+			result:    defaultSessionDuration,
+			unknown:   true,
+		},
+		{
+			// same name with a different path isn't the same role
+			roleArn:   "arn:aws:iam::123456789012:role/path/four-hours",
+			atype:     "primary",
+			client:    &mockIAMClient{maxSessionDuration: 14400, roleArn: "arn:aws:iam::123456789012:role/four-hours"},
+			result:    defaultSessionDuration,
+			unknown:   true,
+			wantCalls: 1,
+		},
+		{
+			roleArn:   "arn:aws:iam::123456789012:role/path/four-hours",
+			atype:     "primary",
+			client:    &mockIAMClient{maxSessionDuration: 14400, roleArn: "arn:aws:iam::123456789012:role/path/four-hours"},
+			result:    14400,
+			wantCalls: 1,
+		},
+		{
+			// cached from the case above so no lookup is needed
+			roleArn: "arn:aws:iam::123456789012:role/path/four-hours",
+			atype:   "primary",
+			client:  &mockIAMClient{SVCErr: errors.New("AccessDenied")},
+			result:  14400,
+		},
+		{
+			roleArn:   "arn:aws:iam::123456789012:role/unreadable",
+			atype:     "primary",
+			client:    &mockIAMClient{SVCErr: errors.New("AccessDenied")},
+			result:    defaultSessionDuration,
+			unknown:   true,
+			wantCalls: 1,
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := &Flow{Name: "test", callerArn: c.callerArn}
+		if len(c.callerArn) < 1 {
+			f.callerArn = "arn:aws:iam::123456789012:user/me"
+		}
+		if c.fromFlow {
+			f.FromFlowConfig = &FromFlowConfig{}
+		}
+		m := Mapping{
+			RoleArn:           c.roleArn,
+			parentConfig:      gc,
+			parentFlow:        f,
+			parentAssumptions: &Assumptions{atype: c.atype},
+		}
+		var result int64
+		if c.client != nil {
+			result = m.resolveMaxDuration(c.client)
+			if c.client.calls != c.wantCalls {
+				t.Errorf("expected %d GetRole calls but got %d", c.wantCalls, c.client.calls)
+			}
+		} else {
+			result = m.resolveMaxDuration(nil)
+		}
+		if result != c.result {
+			t.Errorf("unexpected duration: want %d, got %d", c.result, result)
+		}
+		if m.maxDurationUnknown != c.unknown {
+			t.Errorf("expected maxDurationUnknown %t but got %t", c.unknown, m.maxDurationUnknown)
+		}
+	}
+	// a fresh cache reads what was written to disk
+	c := newDiskCache(filepath.Join(dir, maxSessionDurationCache+".json"))
+	if val, ok := c.get("arn:aws:iam::123456789012:role/path/four-hours", time.Now()); !ok || val != "14400" {
+		t.Errorf("expected cached duration on disk but got '%s' %t", val, ok)
+	}
+	if _, ok := c.get("arn:aws:iam::123456789012:role/path/four-hours", time.Now().Add(maxSessionDurationCacheTTL)); ok {
+		t.Error("expected cache entry to expire")
+	}
+}
+
+func TestWantsMaxDuration(t *testing.T) {
+	cases := []struct {
+		flowDuration string
+		mapping      Mapping
+		result       bool
+	}{
+		{mapping: Mapping{Duration: "max"}, result: true},
+		{flowDuration: "max", mapping: Mapping{}, result: true},
+		{flowDuration: "max", mapping: Mapping{explicitDuration: true}, result: false},
+		{mapping: Mapping{}, result: false},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		m := c.mapping
+		m.parentFlow = &Flow{Duration: c.flowDuration}
+		if got := m.wantsMaxDuration(); got != c.result {
+			t.Errorf("expected %t but got %t", c.result, got)
+		}
+	}
+	if err := validateDurationSetting("test", "forever"); err == nil {
+		t.Error("expected error for unknown duration setting")
+	}
+}
+
+func TestValidateDurations(t *testing.T) {
+	initLog()
+	hub := Mapping{RoleArn: "arn:aws:iam::111111111111:role/hub", ProfileName: "hub"}
+	cases := []struct {
+		flowDuration int64
+		primaries    []Mapping
+		secondaries  []Mapping
+		errContains  []string
+	}{
+		{
+			// chained secondaries over an hour only warn
+			flowDuration: 7200,
+			primaries:    []Mapping{hub},
+			secondaries:  []Mapping{{RoleArn: "arn:aws:iam::222222222222:role/spoke", DurationSeconds: 43200}},
+		},
+		{
+			flowDuration: 3600,
+			primaries:    []Mapping{{RoleArn: "arn:aws:iam::111111111111:role/short", ProfileName: "short", DurationSeconds: 60}},
+			secondaries:  []Mapping{{RoleArn: "arn:aws:iam::222222222222:role/long", DurationSeconds: 50000}},
+			errContains: []string{
+				"'short' (arn:aws:iam::111111111111:role/short) has a session duration of 60",
+				"'arn:aws:iam::222222222222:role/long' has a session duration of 50000",
+			},
+		},
+		{
+			flowDuration: 100000,
+			primaries:    []Mapping{hub},
+			errContains:  []string{"session_duration_seconds 100000 on the flow"},
+		},
+		{
+			// max is resolved at runtime
+			flowDuration: 3600,
+			primaries:    []Mapping{{RoleArn: "arn:aws:iam::111111111111:role/max", Duration: "max"}},
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := newTestChainFlow(c.primaries, c.secondaries)
+		f.DurationSeconds = c.flowDuration
+		_, err := f.Validate()
+		if len(c.errContains) == 0 {
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("expected error but got nil")
+			continue
+		}
+		for _, want := range c.errContains {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("expected error to contain '%s' but got '%s'", want, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package gossamer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+	"gopkg.in/yaml.v2"
+)
+
+// GenerateConfigFromFlow logs in using the named SAML flow and returns
+// a YAML config whose primary mappings list every role in the assertion.
+// Profile names use the aliases from aliasFile (if any) and each mapping
+// is preceded by a comment showing its account and role so that users
+// can prune the result instead of typing out ARNs.
+func (gc *Config) GenerateConfigFromFlow(flowName, aliasFile string) (out []byte, err error) {
+	flow, err := gc.GetFlow(flowName, "saml")
+	if err != nil {
+		return out, err
+	}
+	aliases := make(map[string]string)
+	if len(aliasFile) > 0 {
+		aliases, err = loadAccountAliasFile(aliasFile)
+		if err != nil {
+			return out, err
+		}
+	}
+	// copy before running the flow so values set during
+	// validation don't leak into the generated config
+	genFlow := *flow
+	report, err := flow.ListSAMLRoles()
+	if err != nil {
+		return out, err
+	}
+	genFlow.PAss = &Assumptions{}
+	if flow.PAss != nil {
+		genFlow.PAss.Mappings = append(genFlow.PAss.Mappings, flow.PAss.Mappings...)
+	}
+	// roles granted via more than one IdP need their principal pinned
+	principals := make(map[string]int)
+	for _, role := range report.Roles {
+		principals[role.RoleArn]++
+	}
+	comments := make(map[string]string)
+	existing := len(genFlow.PAss.Mappings)
+	for _, role := range report.Roles {
+		if ok, _ := genFlow.PAss.getMapping(role.RoleArn, role.PrincipalArn); ok {
+			continue
+		}
+		m := Mapping{
+			RoleArn:     role.RoleArn,
+			ProfileName: friendlyProfileName(role.AccountNumber, role.RoleName, aliases, false),
+		}
+		if principals[role.RoleArn] > 1 {
+			m.PrincipalArn = role.PrincipalArn
+		}
+		genFlow.PAss.Mappings = append(genFlow.PAss.Mappings, m)
+	}
+	uniqueProfileNames(genFlow.PAss.Mappings[:existing], genFlow.PAss.Mappings[existing:], aliases)
+	for _, m := range genFlow.PAss.Mappings {
+		rolename, accountnumber, err := parseRoleArn(m.RoleArn)
+		if err != nil {
+			continue
+		}
+		comment := fmt.Sprintf("account: %s", *accountnumber)
+		if alias, ok := aliases[*accountnumber]; ok {
+			comment = fmt.Sprintf("%s (%s)", comment, alias)
+		}
+		comments[m.RoleArn] = fmt.Sprintf("%s role: %s", comment, *rolename)
+	}
+	genConfig := Config{OutFile: gc.OutFile, Flows: []*Flow{&genFlow}}
+	raw, err := yaml.Marshal(&genConfig)
+	if err != nil {
+		return out, err
+	}
+	out = commentMappings(raw, comments)
+	goslogger.Loggo.Info("generated config from flow", "flow", flow.Name, "mappings", len(genFlow.PAss.Mappings))
+	return out, err
+}
+
+// friendlyProfileName builds a profile name from the account alias if
+// one is known and falls back to the usual '<account>_<role>' format.
+// The alias form leaves out the role's path unless withPath is set.
+func friendlyProfileName(accountNumber, roleName string, aliases map[string]string, withPath bool) string {
+	_, name := splitRolePath(roleName)
+	if withPath {
+		name = strings.Replace(roleName, "/", "-", -1)
+	}
+	if alias, ok := aliases[accountNumber]; ok && len(alias) > 0 {
+		return fmt.Sprintf("%s-%s", alias, name)
+	}
+	return fmt.Sprintf("%s_%s", accountNumber, roleName)
+}
+
+// uniqueProfileNames makes the generated mappings' profile names unique
+// among themselves and the existing mappings. Names that collide get the
+// role's path and if that's not enough (e.g., the same role via two IdPs)
+// a numeric suffix.
+func uniqueProfileNames(existing, generated []Mapping, aliases map[string]string) {
+	taken := make(map[string]bool)
+	for _, m := range existing {
+		name := m.ProfileName
+		if rolename, account, err := parseRoleArn(m.RoleArn); len(name) < 1 && err == nil {
+			name = friendlyProfileName(*account, *rolename, nil, false)
+		}
+		taken[name] = true
+	}
+	counts := make(map[string]int)
+	for _, m := range generated {
+		counts[m.ProfileName]++
+	}
+	for i := range generated {
+		m := &generated[i]
+		if counts[m.ProfileName] > 1 || taken[m.ProfileName] {
+			if rolename, account, err := parseRoleArn(m.RoleArn); err == nil {
+				m.ProfileName = friendlyProfileName(*account, *rolename, aliases, true)
+			}
+		}
+		name := m.ProfileName
+		for n := 2; taken[name]; n++ {
+			name = fmt.Sprintf("%s-%d", m.ProfileName, n)
+		}
+		if name != m.ProfileName {
+			goslogger.Loggo.Debug("made generated profile name unique", "roleArn", m.RoleArn, "profileName", name)
+		}
+		m.ProfileName = name
+		taken[name] = true
+	}
+}
+
+var roleArnLineRegex = regexp.MustCompile(`^(\s*)- role_arn: (\S+)$`)
+
+// commentMappings inserts a comment line above each mapping whose
+// role ARN has an entry in comments since yaml.v2 can't write them
+func commentMappings(raw []byte, comments map[string]string) []byte {
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := roleArnLineRegex.FindStringSubmatch(line); match != nil {
+			if comment, ok := comments[strings.Trim(match[2], `"'`)]; ok {
+				fmt.Fprintf(&buf, "%s# %s\n", match[1], comment)
+			}
+		}
+		buf.WriteString(line + "\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,101 @@
+package gossamer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadAccountAliasFile(t *testing.T) {
+	initLog()
+	dir, err := ioutil.TempDir("", "gossamer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"aliases.yml": "123456789012: prod\n210987654321: dev\n",
+		"aliases.csv": "account,alias\n123456789012,prod\n210987654321, dev\n",
+	}
+	for name, contents := range files {
+		fn := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fn, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		aliases, err := loadAccountAliasFile(fn)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+		if aliases["123456789012"] != "prod" || aliases["210987654321"] != "dev" || len(aliases) != 2 {
+			t.Errorf("%s: unexpected aliases: %v", name, aliases)
+		}
+	}
+}
+
+func TestCommentMappings(t *testing.T) {
+	initLog()
+	aliases := map[string]string{"123456789012": "prod"}
+	if name := friendlyProfileName("123456789012", "oo/admin", aliases, false); name != "prod-admin" {
+		t.Errorf("unexpected profile name: got '%s'", name)
+	}
+	if name := friendlyProfileName("123456789012", "oo/admin", aliases, true); name != "prod-oo-admin" {
+		t.Errorf("unexpected profile name: got '%s'", name)
+	}
+	if name := friendlyProfileName("210987654321", "admin", aliases, false); name != "210987654321_admin" {
+		t.Errorf("unexpected profile name: got '%s'", name)
+	}
+	raw := "flows:\n- primary_assumptions:\n    mappings:\n    - role_arn: arn:aws:iam::123456789012:role/admin\n      profile_name: prod-admin\n"
+	out := string(commentMappings([]byte(raw), map[string]string{
+		"arn:aws:iam::123456789012:role/admin": "account: 123456789012 (prod) role: admin",
+	}))
+	want := "    # account: 123456789012 (prod) role: admin\n    - role_arn: arn:aws:iam::123456789012:role/admin\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("expected comment above mapping, got:\n%s", out)
+	}
+}
+
+func TestUniqueProfileNames(t *testing.T) {
+	initLog()
+	aliases := map[string]string{"123456789012": "prod"}
+	existing := []Mapping{
+		{RoleArn: "arn:aws:iam::123456789012:role/mine", ProfileName: "prod-viewer"},
+		{RoleArn: "arn:aws:iam::210987654321:role/admin"},
+	}
+	var generated []Mapping
+	for _, roleArn := range []string{
+		"arn:aws:iam::123456789012:role/ops/admin",
+		"arn:aws:iam::123456789012:role/dev/admin",
+		"arn:aws:iam::123456789012:role/viewer",
+		"arn:aws:iam::123456789012:role/deploy",
+		// the same role via two IdPs
+		"arn:aws:iam::123456789012:role/saml/sso",
+		"arn:aws:iam::123456789012:role/saml/sso",
+		"arn:aws:iam::210987654321:role/admin",
+	} {
+		rolename, account, err := parseRoleArn(roleArn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		generated = append(generated, Mapping{RoleArn: roleArn, ProfileName: friendlyProfileName(*account, *rolename, aliases, false)})
+	}
+	uniqueProfileNames(existing, generated, aliases)
+	expected := []string{
+		"prod-ops-admin",
+		"prod-dev-admin",
+		"prod-viewer-2",
+		"prod-deploy",
+		"prod-saml-sso",
+		"prod-saml-sso-2",
+		"210987654321_admin-2",
+	}
+	for i, want := range expected {
+		fmt.Println("test case: ", i)
+		if got := generated[i].ProfileName; got != want {
+			t.Errorf("unexpected profile name for '%s': want '%s', got '%s'", generated[i].RoleArn, want, got)
+		}
+	}
+}
@@ -4,7 +4,8 @@
 // the primary mappings, and secondary mappings.
 // Mappings are a concept of a role ARN tied to a profile entry name with
 // some additional metadata. Secondary mappings are aware that they must
-// be assumed using a previously established primary mapping.
+// be assumed using a previously established sponsor mapping which can be
+// a primary mapping or another secondary mapping so chains can be any depth.
 //
 // Each flow can then be executed using its Execute method which will run
 // the appropriate auth flow and collect the mappings' credentials.
@@ -26,7 +27,20 @@ func (f *Flow) GetPAss() error {
 	var masterErr error
 	var err error
 	goslogger.Loggo.Info("starting Primary assumptions", "flowName", f.Name)
+	err = f.discoverPrimaryAccounts()
+	if err != nil {
+		goslogger.Loggo.Error("organizations discovery failed", "flowName", f.Name, "error", err)
+		if !f.AllowFailure {
+			return err
+		}
+	}
+	f.markSelection()
 	f.PAss.assumeMappingsConcurrent()
+	err = f.discoverPrimaryRoles()
+	if err != nil {
+		goslogger.Loggo.Error("role discovery failed", "flowName", f.Name, "error", err)
+		err = nil
+	}
 	if !f.AllowFailure {
 		masterErr = err
 	}
@@ -36,40 +50,54 @@ func (f *Flow) GetPAss() error {
 // GetPAssSAML handles the SAML assumptions using the current desird configuration from the flow
 func (f *Flow) GetPAssSAML() error {
 	var masterErr error
-	var err error
-	samluser, err := f.SAMLConfig.Username.gather()
+	sc, err := f.startSAMLSession()
 	if err != nil {
 		return err
 	}
+	// set the session name for later in case we need it for secondary assumptions
+	goslogger.Loggo.Debug("setting roleSessionName on assumptions", "roleSessionName", *sc.roleSessionName)
+	f.PAss.setRoleSessionName(*sc.roleSessionName)
+	// same goes for any session tags and source identity from the assertion
+	f.PAss.setSessionAttributes(sc.getSessionAttributes())
+
+	err = sc.assumeSAMLRoles(f.PAss)
+	if !f.AllowFailure {
+		masterErr = err
+	}
+	return masterErr
+}
+
+// startSAMLSession gathers the flow's SAML parameters and uses them to
+// obtain and decode the SAML assertion. No STS calls are made.
+func (f *Flow) startSAMLSession() (sc *samlSessionConfig, err error) {
+	samluser, err := f.SAMLConfig.Username.gather()
+	if err != nil {
+		return sc, err
+	}
 	samlpass, err := f.SAMLConfig.Password.gather()
 	if err != nil {
-		return err
+		return sc, err
 	}
 	samlurl, err := f.SAMLConfig.URL.gather()
 	if err != nil {
-		return err
+		return sc, err
 	}
 	samltarget, err := f.SAMLConfig.Target.gather()
 	if err != nil {
-		return err
+		return sc, err
+	}
+	idpcert, err := f.SAMLConfig.getIdPCertificate()
+	if err != nil {
+		return sc, err
 	}
 
-	sc := newSAMLSessionConfig(
+	s := newSAMLSessionConfig(
 		f.Name, samluser, samlpass, samlurl, samltarget, f.SAMLConfig.AllowMappingDurationOverride,
 	)
+	sc = &s
+	sc.idpCertificate = idpcert
 	err = sc.startSAMLSession()
-	if err != nil {
-		return err
-	}
-	// set the session name for later in case we need it for secondary assumptions
-	goslogger.Loggo.Debug("setting roleSessionName on assumptions", "roleSessionName", *sc.roleSessionName)
-	f.PAss.setRoleSessionName(*sc.roleSessionName)
-
-	err = sc.assumeSAMLRoles(f.PAss)
-	if !f.AllowFailure {
-		masterErr = err
-	}
-	return masterErr
+	return sc, err
 }
 
 // Execute detects the flow type and runs the appropriate steps to complete
@@ -80,6 +108,9 @@ func (f *Flow) Execute() (err error) {
 	if err != nil {
 		return err
 	}
+	// aliases learned while assuming are used in names before the
+	// secondaries are selected and linked to their sponsors
+	f.PAss.refreshProfileNames()
 	err = f.executeSecondary()
 	return err
 }
@@ -93,7 +124,7 @@ func (f *Flow) executePrimary() (err error) {
 		if err != nil {
 			return err
 		}
-	case "permanent":
+	case "permanent", "from_flow":
 		err = f.GetPAss()
 		if err != nil {
 			return err
@@ -114,8 +145,34 @@ func (f *Flow) executeSecondary() error {
 		// first we need to make absolutely sure we carry over the RoleSessionName for security purposes.
 		rsn := f.PAss.getRoleSessionName()
 		f.SAss.setRoleSessionName(*rsn)
-		// run a precheck on the mappings to make sure stuff is set like duration
-		f.SAss.assumeMappingsConcurrent()
+		// and the session tags and source identity so CloudTrail attribution survives the chain
+		f.SAss.setSessionAttributes(f.PAss.getSessionAttributes())
+		// add any accounts found in organizations now that the sponsor is ready
+		err = f.discoverSecondaryAccounts()
+		if err != nil {
+			goslogger.Loggo.Error("organizations discovery failed", "flowName", f.Name, "error", err)
+			err = nil
+		} else if f.SAss.Organizations != nil {
+			f.markSelection()
+		}
+		// now that all primaries are known link every secondary to its sponsor
+		err = f.resolveSponsors(true)
+		if err != nil {
+			goslogger.Loggo.Error("some secondary mappings will not be assumed", "error", err)
+			err = nil
+		}
+		// each mapping is assumed as soon as its sponsor is ready
+		// unless the SDK will assume them from the config file
+		if !f.SAss.Lazy {
+			f.SAss.assumeMappingsChained()
+		}
+		// then look for more roles trusting the sponsors
+		err = f.discoverSecondaryRoles()
+		if err != nil {
+			goslogger.Loggo.Error("role discovery failed", "flowName", f.Name, "error", err)
+			err = nil
+		}
+		f.SAss.refreshProfileNames()
 	} else {
 		goslogger.Loggo.Info("no secondary assumptions detected so skipping", "flowname", f.Name)
 	}
@@ -0,0 +1,158 @@
+package gossamer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+)
+
+// validateLazy checks that lazy secondary assumptions can be written to
+// the config_file. Settings the AWS config file has no keys for are
+// rejected instead of being dropped silently since they'd change what
+// the SDK's session is allowed to do.
+func (a *Assumptions) validateLazy() (err error) {
+	if a == nil || !a.Lazy {
+		return err
+	}
+	f := a.parentFlow
+	if f != nil && a == f.PAss {
+		err = errors.New("lazy can only be used under secondary_assumptions since primaries need static credentials")
+		return err
+	}
+	if a.parentConfig == nil || len(a.parentConfig.ConfigFile) < 1 {
+		err = errors.New("lazy secondary_assumptions need a config_file to write their profiles to")
+		return err
+	}
+	// the SDK only finds the source_profile in the shared credentials file
+	if shared := sharedCredentialsFile(); !samePath(a.parentConfig.OutFile, shared) {
+		goslogger.Loggo.Warn("output_file isn't the shared credentials file so the SDK won't find the source_profile of lazy profiles",
+			"outputFile", a.parentConfig.OutFile, "sharedCredentialsFile", shared)
+	}
+	// discovered mappings get the discovery's settings
+	mappings := append([]Mapping{}, a.Mappings...)
+	if a.Organizations != nil {
+		mappings = append(mappings, a.Organizations.Mapping)
+	}
+	if a.DiscoverRoles != nil {
+		mappings = append(mappings, a.DiscoverRoles.Mapping)
+	}
+	for i := range mappings {
+		m := &mappings[i]
+		if m.Policy != nil || len(m.PolicyFile) > 0 || len(m.PolicyArns) > 0 ||
+			len(m.SessionTags) > 0 || len(m.TransitiveTagKeys) > 0 || len(m.SourceIdentity) > 0 {
+			msg := fmt.Sprintf("lazy secondary mapping '%s' can't use session policies, session_tags, transitive_tag_keys or source_identity", m.RoleArn)
+			err = errors.New(msg)
+			return err
+		}
+	}
+	return err
+}
+
+// lazyProfileEntries returns the config_file sections that let the AWS
+// SDK assume the mapping itself using its sponsor's profile as the
+// source_profile instead of writing static credentials
+func (m *Mapping) lazyProfileEntries() (entries []configProfileEntry, err error) {
+	if m.sponsorErr != nil {
+		return entries, m.sponsorErr
+	}
+	if m.sponsor == nil {
+		msg := fmt.Sprintf("no sponsor could be found for lazy secondary mapping '%s'", m.RoleArn)
+		err = errors.New(msg)
+		return entries, err
+	}
+	if m.sponsor.NoOutput {
+		msg := fmt.Sprintf("sponsor '%s' of lazy secondary mapping '%s' has no_output so it can't be a source_profile", m.sponsor.mappingID(), m.RoleArn)
+		err = errors.New(msg)
+		return entries, err
+	}
+	// the chain has to start from a profile that was written this run
+	root := m.sponsor
+	for root.sponsor != nil && root.parentAssumptions != nil && root.parentAssumptions.Lazy {
+		root = root.sponsor
+	}
+	if _, err = root.getCredential(); err != nil {
+		msg := fmt.Sprintf("lazy secondary mapping '%s' can't be written since '%s' wasn't assumed", m.RoleArn, root.mappingID())
+		err = errors.New(msg)
+		return entries, err
+	}
+	if m.sponsor.unselected {
+		goslogger.Loggo.Warn("sponsor of lazy secondary mapping isn't being written so its profile may be stale", "roleArn", m.RoleArn, "sponsor", m.sponsor.mappingID())
+	}
+	sponsorEntries, err := m.sponsor.profileEntries()
+	if err != nil {
+		return entries, err
+	}
+	// validate fills in the profile name, region and duration like it does before assuming
+	err = m.validate()
+	if err != nil {
+		return entries, err
+	}
+	m.setDurationIfNotSet(m.parentAssumptions.durationSeconds)
+	if m.wantsMaxDuration() {
+		m.DurationSeconds = m.resolveMaxDuration(nil)
+	}
+	// the SDK chains from the source_profile so the chained limit applies
+	if m.DurationSeconds > maxChainedSessionDuration {
+		goslogger.Loggo.Warn("capping duration of lazy secondary mapping at the role chaining limit",
+			"roleArn", m.RoleArn, "duration", m.DurationSeconds, "cap", maxChainedSessionDuration)
+		m.DurationSeconds = maxChainedSessionDuration
+	}
+	sa, err := m.getSessionAttributes()
+	if err != nil {
+		return entries, err
+	}
+	rsn, err := m.getRoleSessionName()
+	if err != nil {
+		return entries, err
+	}
+	lazy := []string{
+		"role_arn = " + m.RoleArn,
+		"source_profile = " + sponsorEntries[0].profileName,
+	}
+	if sa.externalID != nil && len(*sa.externalID) > 0 {
+		lazy = append(lazy, "external_id = "+*sa.externalID)
+	}
+	lazy = append(lazy, "duration_seconds = "+strconv.FormatInt(m.DurationSeconds, 10))
+	if rsn != nil && len(*rsn) > 0 {
+		lazy = append(lazy, "role_session_name = "+*rsn)
+	}
+	profiles, err := m.profileEntries()
+	if err != nil {
+		return entries, err
+	}
+	for _, e := range profiles {
+		entry := m.configProfileEntry(e)
+		entry.settings = append(append([]string{}, lazy...), entry.settings...)
+		entries = append(entries, entry)
+		goslogger.Loggo.Debug("queued lazy profile", "roleArn", m.RoleArn, "profileName", e.profileName, "sourceProfile", sponsorEntries[0].profileName)
+	}
+	return entries, err
+}
+
+// sharedCredentialsFile returns the credentials file the AWS SDK reads
+func sharedCredentialsFile() string {
+	if filename := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); len(filename) > 0 {
+		return filename
+	}
+	return filepath.Join("~", ".aws", "credentials")
+}
+
+// samePath returns true if both paths point at the same file
+func samePath(a, b string) bool {
+	paths := []string{a, b}
+	for i := range paths {
+		expanded, err := expandHome(paths[i])
+		if err != nil {
+			return false
+		}
+		paths[i], err = filepath.Abs(expanded)
+		if err != nil {
+			return false
+		}
+	}
+	return paths[0] == paths[1]
+}
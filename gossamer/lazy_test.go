@@ -0,0 +1,136 @@
+package gossamer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func TestLazyProfileEntries(t *testing.T) {
+	initLog()
+	cred := &sts.Credentials{
+		AccessKeyId:     aws.String("ASIAFAKE"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}
+	cases := []struct {
+		assumed  bool
+		expected []string
+		fails    bool
+	}{
+		{
+			assumed: true,
+			expected: []string{
+				"[profile workload-us-west-2] role_arn = arn:aws:iam::333333333333:role/workload, source_profile = spoke, " +
+					"duration_seconds = 3600, role_session_name = alice, region = us-west-2, output = json",
+				"[profile spoke] role_arn = arn:aws:iam::222222222222:role/spoke, source_profile = hub, " +
+					"external_id = xyz, duration_seconds = 3600, role_session_name = alice-spoke, region = us-east-1",
+			},
+		},
+		{
+			assumed: false,
+			fails:   true,
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := newTestChainFlow(
+			[]Mapping{{RoleArn: "arn:aws:iam::111111111111:role/hub", ProfileName: "hub"}},
+			[]Mapping{
+				{
+					RoleArn:     "arn:aws:iam::333333333333:role/workload",
+					ProfileName: "workload",
+					Sponsor:     "spoke",
+					Regions:     []string{"us-west-2"},
+					Output:      "json",
+					// capped since the SDK chains it
+					DurationSeconds: 7200,
+				},
+				{
+					RoleArn:         "arn:aws:iam::222222222222:role/spoke",
+					ProfileName:     "spoke",
+					Region:          "us-east-1",
+					Sponsor:         "hub",
+					ExternalID:      &CParam{Source: "config", Value: "xyz"},
+					RoleSessionName: "{{.InheritedName}}-spoke",
+				},
+			},
+		)
+		f.parentConfig.ConfigFile = "config"
+		f.SAss.Lazy = true
+		if err := f.SAss.validateLazy(); err != nil {
+			t.Errorf("unexpected validation error: %s", err)
+			continue
+		}
+		if c.assumed {
+			f.PAss.Mappings[0].credential = cred
+		}
+		f.SAss.setRoleSessionName("alice")
+		if err := f.resolveSponsors(true); err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		pfis, err := f.SAss.getAcfmgrProfileInputs()
+		if len(pfis) > 0 {
+			t.Errorf("expected no static credentials for lazy mappings but got %d", len(pfis))
+		}
+		if c.fails {
+			if err == nil || len(f.configEntries) > 0 {
+				t.Errorf("expected lazy mappings to fail without an assumed sponsor but got %v, %d entries", err, len(f.configEntries))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		var got []string
+		for _, e := range f.configEntries {
+			got = append(got, e.header()+" "+strings.Join(e.settings, ", "))
+		}
+		if strings.Join(got, "\n") != strings.Join(c.expected, "\n") {
+			t.Errorf("unexpected lazy profiles:\nwant %v\ngot  %v", c.expected, got)
+		}
+	}
+}
+
+func TestValidateLazy(t *testing.T) {
+	initLog()
+	cases := []struct {
+		lazyPrimary bool
+		configFile  string
+		mapping     Mapping
+		errContains string
+	}{
+		{configFile: "config", mapping: Mapping{RoleArn: "arn:aws:iam::222222222222:role/spoke", ExternalID: &CParam{Source: "config", Value: "x"}}},
+		{configFile: "config", lazyPrimary: true, errContains: "only be used under secondary_assumptions"},
+		{mapping: Mapping{RoleArn: "arn:aws:iam::222222222222:role/spoke"}, errContains: "need a config_file"},
+		{configFile: "config", mapping: Mapping{RoleArn: "arn:aws:iam::222222222222:role/spoke", SessionTags: map[string]string{"a": "b"}}, errContains: "can't use session policies"},
+		{configFile: "config", mapping: Mapping{RoleArn: "arn:aws:iam::222222222222:role/spoke", PolicyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}}, errContains: "can't use session policies"},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := newTestChainFlow([]Mapping{{RoleArn: "arn:aws:iam::111111111111:role/hub"}}, []Mapping{c.mapping})
+		f.parentConfig.ConfigFile = c.configFile
+		a := f.SAss
+		if c.lazyPrimary {
+			a = f.PAss
+		}
+		a.Lazy = true
+		err := a.validateLazy()
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("expected error containing '%s' but got '%v'", c.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+}
@@ -3,9 +3,12 @@ package gossamer
 import (
 	"errors"
 	"fmt"
+	"sort"
+
 	"github.com/GESkunkworks/gossamer/goslogger"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/sts"
 )
 
@@ -21,19 +24,46 @@ func newSAMLMapping(roleArn, principalArn string, sc *samlSessionConfig) *Mappin
 // and their desired profile name to be written to the
 // credentials file after they've been assumed.
 type Mapping struct {
-	RoleArn           string `yaml:"role_arn"`
-	ProfileName       string `yaml:"profile_name,omitempty"`
-	Region            string `yaml:"region,omitempty"`
-	NoOutput          bool   `yaml:"no_output,omitempty"`
-	SponsorCredsArn   string `yaml:"sponsor_creds_arn,omitempty"`
-	credential        *sts.Credentials
-	DurationSeconds   int64 `yaml:"session_duration_seconds,omitempty"`
-	parentAssumptions *Assumptions
-	parentFlow        *Flow
-	parentConfig      *Config
-	parentSAMLConfig  *samlSessionConfig
-	samlPrincipalArn  string
-	userDefined       bool
+	RoleArn                   string            `yaml:"role_arn"`
+	ProfileName               string            `yaml:"profile_name,omitempty"`
+	Region                    string            `yaml:"region,omitempty"`
+	Regions                   []string          `yaml:"regions,omitempty"`
+	RegionProfileNameTemplate string            `yaml:"region_profile_name_template,omitempty"`
+	NoOutput                  bool              `yaml:"no_output,omitempty"`
+	SponsorCredsArn           string            `yaml:"sponsor_creds_arn,omitempty"`
+	Sponsor                   string            `yaml:"sponsor,omitempty"`
+	PrincipalArn              string            `yaml:"principal_arn,omitempty"`
+	Tags                      []string          `yaml:"tags,omitempty"`
+	ExternalID                *CParam           `yaml:"external_id,omitempty"`
+	SessionTags               map[string]string `yaml:"session_tags,omitempty"`
+	TransitiveTagKeys         []string          `yaml:"transitive_tag_keys,omitempty"`
+	SourceIdentity            string            `yaml:"source_identity,omitempty"`
+	PolicyArns                []string          `yaml:"policy_arns,omitempty"`
+	Policy                    interface{}       `yaml:"policy,omitempty"`
+	PolicyFile                string            `yaml:"policy_file,omitempty"`
+	RoleSessionName           string            `yaml:"role_session_name,omitempty"`
+	Output                    string            `yaml:"output,omitempty"`
+	CLIPager                  *string           `yaml:"cli_pager,omitempty"`
+	Extra                     map[string]string `yaml:"extra,omitempty"`
+	policyJSON                string
+	credential                *sts.Credentials
+	sponsor                   *Mapping
+	sharedFrom                *Mapping
+	sponsorErr                error
+	DurationSeconds           int64  `yaml:"session_duration_seconds,omitempty"`
+	Duration                  string `yaml:"duration,omitempty"`
+	parentAssumptions         *Assumptions
+	parentFlow                *Flow
+	parentConfig              *Config
+	parentSAMLConfig          *samlSessionConfig
+	samlPrincipalArn          string
+	userDefined               bool
+	generatedProfileName      bool
+	explicitDuration          bool
+	validated                 bool
+	maxDurationUnknown        bool
+	unselected                bool
+	skip                      bool
 }
 
 func (m *Mapping) setRelationships(a *Assumptions, f *Flow, gc *Config) (err error) {
@@ -57,6 +87,12 @@ func (m *Mapping) getCredential() (cred *sts.Credentials, err error) {
 // to mappings before they can be written out
 func (m *Mapping) validate() (err error) {
 	var blankDuration int64
+	// remember if the user set a number so a flow level max doesn't override it
+	// (only the first time since the number is filled in below)
+	if !m.validated {
+		m.explicitDuration = m.explicitDuration || m.DurationSeconds != blankDuration
+		m.validated = true
+	}
 	// if m.parentAssumptions.durationSeconds == nil {
 	// 	m.parentAssumptions.durationSeconds = blankDuration
 	// }
@@ -94,11 +130,12 @@ func (m *Mapping) validate() (err error) {
 	}
 	if len(m.ProfileName) < 1 {
 		goslogger.Loggo.Debug("detected missing profile name", "roleArn", m.RoleArn)
-		uid, err := getRoleUniqueID(m.RoleArn)
+		name, err := m.generateProfileName()
 		if err != nil {
 			return err
 		}
-		m.ProfileName = *uid
+		m.ProfileName = name
+		m.generatedProfileName = true
 		goslogger.Loggo.Debug("set profilename", "profileName", m.ProfileName)
 	}
 	if !m.parentAssumptions.doNotPropagateRegion {
@@ -119,6 +156,88 @@ func (m *Mapping) setDurationIfNotSet(duration int64) {
 	}
 }
 
+// getSessionAttributes combines the session attributes passed down from
+// a SAML assertion (when the flow opts in) with the ones configured on the
+// flow and mapping. Mapping settings override the flow's but anything from
+// the assertion wins since AWS won't let it change partway through a role
+// chain. Transitive tags from the assertion are never passed again since
+// AWS already carries them down the chain.
+func (m *Mapping) getSessionAttributes() (sa *sessionAttributes, err error) {
+	var inherited *sessionAttributes
+	if m.parentAssumptions != nil {
+		inherited = m.parentAssumptions.getSessionAttributes()
+	}
+	f := m.parentFlow
+	if f == nil {
+		f = &Flow{}
+	}
+	tags := make(map[string]string)
+	for k, v := range f.SessionTags {
+		tags[k] = v
+	}
+	for k, v := range m.SessionTags {
+		tags[k] = v
+	}
+	transitiveTagKeys := m.TransitiveTagKeys
+	if len(transitiveTagKeys) == 0 {
+		transitiveTagKeys = f.TransitiveTagKeys
+	}
+	sourceIdentity := m.SourceIdentity
+	if len(sourceIdentity) == 0 {
+		sourceIdentity = f.SourceIdentity
+	}
+	externalID := m.ExternalID
+	if externalID == nil {
+		externalID = f.ExternalID
+	}
+	sa = &sessionAttributes{}
+	if inherited != nil && !f.PropagateSAMLAttributes {
+		inherited = nil
+	}
+	if inherited != nil {
+		for _, t := range inherited.tags {
+			if v, ok := tags[*t.Key]; ok && v != *t.Value {
+				goslogger.Loggo.Warn("session tag from SAML assertion overrides configured value", "roleArn", m.RoleArn, "key", *t.Key)
+			}
+			tags[*t.Key] = *t.Value
+		}
+		if inherited.sourceIdentity != nil && len(*inherited.sourceIdentity) > 0 {
+			if len(sourceIdentity) > 0 && sourceIdentity != *inherited.sourceIdentity {
+				goslogger.Loggo.Warn("source identity from SAML assertion overrides configured value", "roleArn", m.RoleArn)
+			}
+			sourceIdentity = *inherited.sourceIdentity
+		}
+	}
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sa.tags = append(sa.tags, &sts.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	for _, k := range transitiveTagKeys {
+		if _, ok := tags[k]; !ok {
+			msg := fmt.Sprintf("transitive tag key '%s' for mapping '%s' is not one of its session tags", k, m.RoleArn)
+			err = errors.New(msg)
+			return sa, err
+		}
+		sa.transitiveTagKeys = append(sa.transitiveTagKeys, aws.String(k))
+	}
+	if len(sourceIdentity) > 0 {
+		sa.sourceIdentity = aws.String(sourceIdentity)
+	}
+	if externalID != nil {
+		val, err := externalID.gather()
+		if err != nil {
+			return sa, err
+		}
+		sa.externalID = aws.String(val)
+	}
+	sa.setSessionPolicy(m)
+	return sa, err
+}
+
 func (m *Mapping) assumeNonSAML() (err error) {
 	goslogger.Loggo.Debug("assuming non-SAML mapping")
 	var sess *session.Session
@@ -128,27 +247,22 @@ func (m *Mapping) assumeNonSAML() (err error) {
 			return err
 		}
 	} else if m.parentAssumptions.atype == "secondary" {
-		var sponsorCred *sts.Credentials
-		// try to locate sponsor creds for this secondary assumption
-		if len(m.SponsorCredsArn) < 1 && len(m.parentFlow.PAss.Mappings) > 1 {
-			msg := fmt.Sprintf("no sponsor_creds_arn specified for secondary mapping '%s' and too many primary mappings to make an inference", m.RoleArn)
+		// sponsors were linked by resolveSponsors before any secondaries started
+		if m.sponsorErr != nil {
+			return m.sponsorErr
+		}
+		if m.sponsor == nil {
+			msg := fmt.Sprintf("no sponsor could be found for secondary mapping '%s'", m.RoleArn)
 			err = errors.New(msg)
-		} else if len(m.SponsorCredsArn) < 1 {
-			// means user didn't put anything in config file for sponsor creds
-			// however, if there's only one set of primary creds we can infer
-			goslogger.Loggo.Debug("detected missing sponsor creds arn in secondary mapping")
-			if len(m.parentFlow.PAss.Mappings) == 1 {
-				goslogger.Loggo.Debug("since only one set of creds in primary assumptions we'll take sponsorcreds from there")
-				sponsorCred, err = m.parentFlow.PAss.getMappingCredential(m.parentFlow.PAss.Mappings[0].RoleArn)
-			}
-		} else {
-			sponsorCred, err = m.parentFlow.PAss.getMappingCredential(m.SponsorCredsArn)
+			return err
 		}
+		sponsorCred, err := m.sponsor.getCredential()
 		if err != nil {
 			msg := fmt.Sprintf("error getting sponsor creds for secondary mapping: %s", err)
 			err = errors.New(msg)
 			return err
 		}
+		goslogger.Loggo.Debug("got sponsor creds for secondary mapping", "roleArn", m.RoleArn, "sponsor", m.sponsor.RoleArn)
 		// now we can get our session from the sponsor
 		sess, err = session.NewSessionWithOptions(session.Options{
 			Config: aws.Config{Credentials: convertSCredsToCreds(sponsorCred)},
@@ -158,12 +272,24 @@ func (m *Mapping) assumeNonSAML() (err error) {
 		}
 	}
 	m.setDurationIfNotSet(m.parentAssumptions.durationSeconds)
+	sa, err := m.getSessionAttributes()
+	if err != nil {
+		return err
+	}
+	rsn, err := m.getRoleSessionName()
+	if err != nil {
+		return err
+	}
+	if sess != nil && m.wantsMaxDuration() {
+		m.DurationSeconds = m.resolveMaxDuration(iam.New(sess))
+	}
 	if sess != nil {
 		client := sts.New(sess)
 		m.credential, err = assumeRoleWithClient(
 			&m.RoleArn,
-			m.parentAssumptions.getRoleSessionName(),
+			rsn,
 			&m.DurationSeconds,
+			sa,
 			client,
 		)
 		if err != nil {
@@ -177,12 +303,24 @@ func (m *Mapping) assumeNonSAML() (err error) {
 }
 
 func (m *Mapping) assumeSAML() (err error) {
+	// AssumeRoleWithSAML takes these from the assertion instead
+	if m.ExternalID != nil || len(m.SessionTags) > 0 || len(m.TransitiveTagKeys) > 0 || len(m.SourceIdentity) > 0 || len(m.RoleSessionName) > 0 {
+		goslogger.Loggo.Warn("external_id, session_tags, transitive_tag_keys, source_identity and role_session_name are ignored for roles assumed with SAML", "roleArn", m.RoleArn)
+	}
+	// the assertion's SessionDuration wins over max like any other duration
+	if m.wantsMaxDuration() && m.parentSAMLConfig.getSessionDuration() <= 0 && m.parentSAMLConfig.allowMappingDurationOverride {
+		m.DurationSeconds = m.resolveMaxDuration(nil)
+	}
+	// session policies are allowed though
+	sa := &sessionAttributes{}
+	sa.setSessionPolicy(m)
 	m.credential, err = assumeSAMLRoleWithSession(
 		&m.samlPrincipalArn,
 		&m.RoleArn,
 		m.parentSAMLConfig.roleSessionName,
 		m.parentSAMLConfig.assertion,
 		&m.DurationSeconds,
+		sa,
 		m.parentSAMLConfig.stsClient,
 	)
 	return err
@@ -190,6 +328,9 @@ func (m *Mapping) assumeSAML() (err error) {
 
 // assume attempts to handle the assumption of the mapping
 func (m *Mapping) assume() (err error) {
+	if m.sharedFrom != nil {
+		return m.assumeShared()
+	}
 	err = m.validate()
 	if err != nil {
 		return err
@@ -209,6 +350,11 @@ func (m *Mapping) assume() (err error) {
 			goslogger.Loggo.Debug("successfully assumed role", "profileName", m.ProfileName)
 		}
 	}
+	if err == nil {
+		m.logGrantedDuration()
+		m.learnMaxDuration()
+		m.learnAccountAlias()
+	}
 	return err
 }
 
@@ -0,0 +1,147 @@
+package gossamer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func TestMappingSessionAttributes(t *testing.T) {
+	initLog()
+	cases := []struct {
+		flow        Flow
+		mapping     Mapping
+		inherited   *sessionAttributes
+		tags        string
+		transitive  string
+		sourceID    string
+		externalID  string
+		errContains string
+	}{
+		{
+			// mapping overrides the flow defaults key by key
+			flow: Flow{
+				SessionTags:    map[string]string{"team": "red", "cost": "123"},
+				SourceIdentity: "flow-user",
+				ExternalID:     &CParam{Source: "config", Value: "flow-ext"},
+			},
+			mapping: Mapping{
+				SessionTags:       map[string]string{"team": "blue"},
+				TransitiveTagKeys: []string{"team"},
+				ExternalID:        &CParam{Source: "config", Value: "mapping-ext"},
+			},
+			tags:       "cost=123,team=blue",
+			transitive: "team",
+			sourceID:   "flow-user",
+			externalID: "mapping-ext",
+		},
+		{
+			// values from the SAML assertion always win when propagated
+			flow: Flow{SessionTags: map[string]string{"email": "spoof"}, SourceIdentity: "someone", PropagateSAMLAttributes: true},
+			inherited: &sessionAttributes{
+				tags:              []*sts.Tag{{Key: aws.String("email"), Value: aws.String("me@example.com")}},
+				transitiveTagKeys: []*string{aws.String("dept")},
+				sourceIdentity:    aws.String("me"),
+			},
+			tags:     "email=me@example.com",
+			sourceID: "me",
+		},
+		{
+			// the assertion's attributes aren't sent again unless the flow asks
+			flow: Flow{SessionTags: map[string]string{"email": "configured"}},
+			inherited: &sessionAttributes{
+				tags:              []*sts.Tag{{Key: aws.String("email"), Value: aws.String("me@example.com")}},
+				transitiveTagKeys: []*string{aws.String("dept")},
+				sourceIdentity:    aws.String("me"),
+			},
+			tags: "email=configured",
+		},
+		{
+			mapping:     Mapping{TransitiveTagKeys: []string{"missing"}},
+			errContains: "transitive tag key 'missing'",
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		flow := c.flow
+		m := c.mapping
+		m.RoleArn = "arn:aws:iam::123456789012:role/test"
+		m.parentFlow = &flow
+		m.parentAssumptions = &Assumptions{sessionAttributes: c.inherited}
+		sa, err := m.getSessionAttributes()
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("expected error containing '%s' but got '%v'", c.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		input := sts.AssumeRoleInput{}
+		sa.apply(&input)
+		var tags, transitive []string
+		for _, tag := range input.Tags {
+			tags = append(tags, *tag.Key+"="+*tag.Value)
+		}
+		for _, k := range input.TransitiveTagKeys {
+			transitive = append(transitive, *k)
+		}
+		if got := strings.Join(tags, ","); got != c.tags {
+			t.Errorf("expected tags '%s' but got '%s'", c.tags, got)
+		}
+		if got := strings.Join(transitive, ","); got != c.transitive {
+			t.Errorf("expected transitive tag keys '%s' but got '%s'", c.transitive, got)
+		}
+		if got := aws.StringValue(input.SourceIdentity); got != c.sourceID {
+			t.Errorf("expected source identity '%s' but got '%s'", c.sourceID, got)
+		}
+		if got := aws.StringValue(input.ExternalId); got != c.externalID {
+			t.Errorf("expected external id '%s' but got '%s'", c.externalID, got)
+		}
+	}
+}
+
+// run with -race to catch mappings sharing an external ID while they're
+// assumed concurrently
+func TestSharedExternalIDConcurrent(t *testing.T) {
+	initLog()
+	os.Setenv("GOSSAMER_TEST_EXTERNAL_ID", "shared-ext")
+	defer os.Unsetenv("GOSSAMER_TEST_EXTERNAL_ID")
+	// matrix mappings are copies so they share the pointer
+	template := Mapping{ExternalID: &CParam{Source: "env", Value: "GOSSAMER_TEST_EXTERNAL_ID"}}
+	var mappings []Mapping
+	for i := 0; i < 10; i++ {
+		m := template
+		m.RoleArn = fmt.Sprintf("arn:aws:iam::%012d:role/shared", i)
+		mappings = append(mappings, m, Mapping{RoleArn: fmt.Sprintf("arn:aws:iam::%012d:role/flow", i)})
+	}
+	f := newTestChainFlow(mappings, nil)
+	f.ExternalID = &CParam{Source: "env", Value: "GOSSAMER_TEST_EXTERNAL_ID"}
+	var wg sync.WaitGroup
+	results := make([]string, len(f.PAss.Mappings))
+	for i := range f.PAss.Mappings {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sa, err := f.PAss.Mappings[i].getSessionAttributes()
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+				return
+			}
+			results[i] = aws.StringValue(sa.externalID)
+		}(i)
+	}
+	wg.Wait()
+	for i, got := range results {
+		if got != "shared-ext" {
+			t.Errorf("mapping %d: expected external ID 'shared-ext' but got '%s'", i, got)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package gossamer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+	"gopkg.in/yaml.v2"
+)
+
+// MatrixMapping describes the same role in many accounts. It's expanded
+// into one regular mapping per account when the config is parsed and
+// every other mapping setting (e.g., sponsor, region) is copied to them.
+type MatrixMapping struct {
+	RoleName string `yaml:"role_name"`
+	// Path is the role's IAM path (e.g., '/ops/') and defaults to '/'
+	Path         string   `yaml:"path,omitempty"`
+	Accounts     []string `yaml:"accounts,omitempty"`
+	AccountsFile string   `yaml:"accounts_file,omitempty"`
+	// ProfileNameTemplate names the generated mappings. When blank the
+	// usual profile_name_template inheritance applies.
+	ProfileNameTemplate string `yaml:"profile_name_template,omitempty"`
+	Mapping             `yaml:",inline"`
+}
+
+// loadAccountListFile reads account numbers from either a YAML list or
+// a file with one account per line. Lines can have more columns (e.g., an
+// alias file) in which case the first is used. The format is picked by
+// file extension.
+func loadAccountListFile(filename string) (accounts []string, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return accounts, err
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &accounts)
+		if err != nil {
+			return accounts, err
+		}
+	default:
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		r.Comment = '#'
+		records, err := r.ReadAll()
+		if err != nil {
+			return accounts, err
+		}
+		for i, rec := range records {
+			account := strings.TrimSpace(rec[0])
+			// allow a header row
+			if i == 0 && !accountNumberRegex.MatchString(account) {
+				continue
+			}
+			accounts = append(accounts, account)
+		}
+	}
+	goslogger.Loggo.Debug("loaded account list", "filename", filename, "count", len(accounts))
+	return accounts, err
+}
+
+// getAccounts returns the accounts from the list and file combined
+func (mm *MatrixMapping) getAccounts() (accounts []string, err error) {
+	accounts = append(accounts, mm.Accounts...)
+	if len(mm.AccountsFile) > 0 {
+		fromFile, err := loadAccountListFile(mm.AccountsFile)
+		if err != nil {
+			msg := fmt.Sprintf("unable to load accounts_file '%s': %s", mm.AccountsFile, err)
+			err = errors.New(msg)
+			return accounts, err
+		}
+		accounts = append(accounts, fromFile...)
+	}
+	seen := make(map[string]bool)
+	var unique []string
+	for _, account := range accounts {
+		account = strings.TrimSpace(account)
+		if !accountNumberRegex.MatchString(account) {
+			msg := fmt.Sprintf("matrix mapping for role '%s' has invalid account number '%s'", mm.RoleName, account)
+			err = errors.New(msg)
+			return unique, err
+		}
+		if seen[account] {
+			continue
+		}
+		seen[account] = true
+		unique = append(unique, account)
+	}
+	return unique, err
+}
+
+// roleArn builds the ARN of the matrix role in the given account
+func (mm *MatrixMapping) roleArn(partition, account string) string {
+	path := strings.Trim(mm.Path, "/")
+	if len(path) > 0 {
+		path += "/"
+	}
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s%s", partition, account, path, mm.RoleName)
+}
+
+// partitionFromArn returns the partition field of an ARN (e.g., 'aws-cn')
+func partitionFromArn(arn string) string {
+	if fields := strings.Split(arn, ":"); len(fields) > 4 && fields[0] == "arn" {
+		return fields[1]
+	}
+	return ""
+}
+
+// regionPartition returns the partition a region belongs to
+func regionPartition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	}
+	return "aws"
+}
+
+// partition returns the partition for ARNs the flow builds from account
+// numbers. Everything in a flow starts from the same credentials so it's
+// taken from the flow's identity or its primary mappings when known and
+// otherwise from the region.
+func (f *Flow) partition(region string) string {
+	if p := partitionFromArn(f.callerArn); len(p) > 0 {
+		return p
+	}
+	if f.PAss != nil {
+		for _, m := range f.PAss.Mappings {
+			if p := partitionFromArn(m.RoleArn); len(p) > 0 {
+				return p
+			}
+		}
+	}
+	if len(region) < 1 {
+		region = f.Region
+	}
+	return regionPartition(region)
+}
+
+// expand returns one mapping per account of the assumptions
+func (mm *MatrixMapping) expand(a *Assumptions, f *Flow, gc *Config) (mappings []Mapping, err error) {
+	if len(mm.RoleName) < 1 || strings.Contains(mm.RoleName, "/") {
+		msg := fmt.Sprintf("matrix mapping role_name '%s' must be set and can't contain '/' (use path instead)", mm.RoleName)
+		err = errors.New(msg)
+		return mappings, err
+	}
+	if len(mm.RoleArn) > 0 || len(mm.ProfileName) > 0 {
+		msg := fmt.Sprintf("matrix mapping for role '%s' can't set role_arn or profile_name (use profile_name_template instead)", mm.RoleName)
+		err = errors.New(msg)
+		return mappings, err
+	}
+	accounts, err := mm.getAccounts()
+	if err != nil {
+		return mappings, err
+	}
+	if len(accounts) < 1 {
+		msg := fmt.Sprintf("matrix mapping for role '%s' has no accounts", mm.RoleName)
+		err = errors.New(msg)
+		return mappings, err
+	}
+	if mm.ExternalID != nil {
+		mm.ExternalID.name = "ExternalID for matrix role " + mm.RoleName
+		mm.ExternalID.parentflow = f.Name
+	}
+	partition := f.partition(mm.Region)
+	for _, account := range accounts {
+		m := mm.Mapping
+		m.RoleArn = mm.roleArn(partition, account)
+		// relationships aren't set yet but the template needs the flow's
+		// region and the closest normalization
+		m.setRelationships(a, f, gc)
+		if len(mm.ProfileNameTemplate) > 0 {
+			m.ProfileName, err = m.renderProfileName(mm.ProfileNameTemplate)
+			if err != nil {
+				return mappings, err
+			}
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, err
+}
+
+// expandMatrix adds the mappings described by the matrix entries
+// to the assumptions' regular mappings
+func (a *Assumptions) expandMatrix(f *Flow, gc *Config) (err error) {
+	for _, mm := range a.Matrix {
+		mappings, err := mm.expand(a, f, gc)
+		if err != nil {
+			return err
+		}
+		goslogger.Loggo.Debug("expanded matrix mapping", "flow", f.Name, "roleName", mm.RoleName, "count", len(mappings))
+		a.Mappings = append(a.Mappings, mappings...)
+	}
+	return err
+}
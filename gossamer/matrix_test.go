@@ -0,0 +1,147 @@
+package gossamer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	initLog()
+	dir, err := ioutil.TempDir("", "gossamer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	accountsFile := filepath.Join(dir, "accounts.csv")
+	if err := ioutil.WriteFile(accountsFile, []byte("account,alias\n333333333333,dev\n# retired\n222222222222,test\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "config.yml")
+	config := `output_file: ./creds
+account_aliases:
+  "222222222222": test
+flows:
+- name: org
+  region: us-east-1
+  permanent: {}
+  primary_assumptions:
+    mappings:
+    - role_arn: arn:aws:iam::111111111111:role/hub
+      profile_name: hub
+  secondary_assumptions:
+    profile_name_normalization:
+      case: upper
+    matrix:
+    - role_name: OrganizationAccountAccessRole
+      accounts: ["222222222222", "444444444444"]
+      accounts_file: ` + accountsFile + `
+      profile_name_template: "{{.AccountAlias}}-admin-{{.Region}}"
+      sponsor: hub
+      session_tags:
+        team: ops
+    - role_name: readonly
+      path: /ops/
+      accounts: ["222222222222"]
+      sponsor: hub
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gc := Config{}
+	if err := gc.ParseConfigFile(configFile); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f := gc.Flows[0]
+	expected := []struct {
+		roleArn     string
+		profileName string
+	}{
+		{"arn:aws:iam::222222222222:role/OrganizationAccountAccessRole", "TEST-ADMIN-US-EAST-1"},
+		{"arn:aws:iam::444444444444:role/OrganizationAccountAccessRole", "444444444444-ADMIN-US-EAST-1"},
+		{"arn:aws:iam::333333333333:role/OrganizationAccountAccessRole", "333333333333-ADMIN-US-EAST-1"},
+		{"arn:aws:iam::222222222222:role/ops/readonly", ""},
+	}
+	if len(f.SAss.Mappings) != len(expected) {
+		t.Fatalf("expected %d mappings but got %d", len(expected), len(f.SAss.Mappings))
+	}
+	for i, e := range expected {
+		fmt.Println("test case: ", i)
+		m := f.SAss.Mappings[i]
+		if m.RoleArn != e.roleArn || m.ProfileName != e.profileName {
+			t.Errorf("unexpected mapping: want %s/%s, got %s/%s", e.roleArn, e.profileName, m.RoleArn, m.ProfileName)
+		}
+		if m.Sponsor != "hub" || m.parentFlow != f {
+			t.Errorf("expected mapping to keep matrix settings and relationships: %+v", m)
+		}
+	}
+	if f.SAss.Mappings[0].SessionTags["team"] != "ops" {
+		t.Error("expected session tags to be copied from the matrix")
+	}
+	if _, err := f.Validate(); err != nil {
+		t.Errorf("unexpected error validating expanded flow: %s", err)
+	}
+}
+
+func TestExpandMatrixErrors(t *testing.T) {
+	initLog()
+	cases := []struct {
+		mm          MatrixMapping
+		errContains string
+	}{
+		{MatrixMapping{RoleName: "ops/admin", Accounts: []string{"222222222222"}}, "can't contain '/'"},
+		{MatrixMapping{RoleName: "admin"}, "has no accounts"},
+		{MatrixMapping{RoleName: "admin", Accounts: []string{"2222"}}, "invalid account number '2222'"},
+		{MatrixMapping{RoleName: "admin", Accounts: []string{"222222222222"}, Mapping: Mapping{ProfileName: "admin"}}, "can't set role_arn or profile_name"},
+		{MatrixMapping{RoleName: "admin", AccountsFile: "/does/not/exist.txt"}, "unable to load accounts_file"},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := newTestChainFlow(nil, nil)
+		_, err := c.mm.expand(f.SAss, f, f.parentConfig)
+		if err == nil || !strings.Contains(err.Error(), c.errContains) {
+			t.Errorf("expected error containing '%s' but got '%v'", c.errContains, err)
+		}
+	}
+}
+
+func TestExpandMatrixPartition(t *testing.T) {
+	initLog()
+	cases := []struct {
+		primaries []Mapping
+		callerArn string
+		region    string
+		expected  string
+	}{
+		{expected: "arn:aws:iam::222222222222:role/admin"},
+		{region: "us-gov-west-1", expected: "arn:aws-us-gov:iam::222222222222:role/admin"},
+		{region: "cn-north-1", expected: "arn:aws-cn:iam::222222222222:role/admin"},
+		{
+			primaries: []Mapping{{RoleArn: "arn:aws-cn:iam::111111111111:role/hub"}},
+			expected:  "arn:aws-cn:iam::222222222222:role/admin",
+		},
+		{
+			callerArn: "arn:aws-us-gov:iam::111111111111:user/me",
+			region:    "us-east-1",
+			expected:  "arn:aws-us-gov:iam::222222222222:role/admin",
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := newTestChainFlow(c.primaries, nil)
+		f.callerArn = c.callerArn
+		f.Region = c.region
+		mm := MatrixMapping{RoleName: "admin", Accounts: []string{"222222222222"}}
+		mappings, err := mm.expand(f.SAss, f, f.parentConfig)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if len(mappings) != 1 || mappings[0].RoleArn != c.expected {
+			t.Errorf("expected role '%s' but got %+v", c.expected, mappings)
+		}
+	}
+}
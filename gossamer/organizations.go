@@ -0,0 +1,237 @@
+package gossamer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+)
+
+const organizationsDefaultRegion = "us-east-1"
+
+// OrganizationsDiscovery builds mappings for a role in every account
+// found in AWS Organizations when the flow runs. The accounts can be
+// narrowed down to some OUs and to accounts with certain tags. Like a
+// matrix mapping any other mapping settings are copied to the results.
+// Under secondary assumptions the sponsor's credentials are used to
+// list the accounts and to assume the roles. Under primary assumptions
+// the flow's starting credentials are used for both.
+type OrganizationsDiscovery struct {
+	RoleName string `yaml:"role_name"`
+	Path     string `yaml:"path,omitempty"`
+	// ParentIDs limits discovery to accounts directly under these OUs or roots
+	ParentIDs []string `yaml:"parent_ids,omitempty"`
+	// AccountTags limits discovery to accounts having all of these tags
+	AccountTags         map[string]string `yaml:"account_tags,omitempty"`
+	ExcludeAccounts     []string          `yaml:"exclude_accounts,omitempty"`
+	ProfileNameTemplate string            `yaml:"profile_name_template,omitempty"`
+	Mapping             `yaml:",inline"`
+}
+
+// validate checks the discovery settings before anything runs
+func (d *OrganizationsDiscovery) validate(a *Assumptions) (err error) {
+	if d == nil {
+		return err
+	}
+	if a.parentFlow != nil && a.parentFlow.SAMLConfig != nil && a == a.parentFlow.PAss {
+		err = errors.New("organizations discovery isn't supported on SAML primary assumptions, use it under secondary_assumptions instead")
+		return err
+	}
+	if len(d.RoleName) < 1 || strings.Contains(d.RoleName, "/") {
+		msg := fmt.Sprintf("organizations role_name '%s' must be set and can't contain '/' (use path instead)", d.RoleName)
+		err = errors.New(msg)
+		return err
+	}
+	if len(d.RoleArn) > 0 || len(d.ProfileName) > 0 {
+		msg := fmt.Sprintf("organizations discovery for role '%s' can't set role_arn or profile_name (use profile_name_template instead)", d.RoleName)
+		err = errors.New(msg)
+		return err
+	}
+	if len(d.ProfileNameTemplate) > 0 {
+		_, err = parseProfileNameTemplate(d.ProfileNameTemplate)
+	}
+	return err
+}
+
+// listAccounts returns the active accounts picked by the discovery settings
+func (d *OrganizationsDiscovery) listAccounts(client organizationsiface.OrganizationsAPI) (accounts []*organizations.Account, err error) {
+	var found []*organizations.Account
+	collect := func(page []*organizations.Account) {
+		found = append(found, page...)
+	}
+	if len(d.ParentIDs) < 1 {
+		err = client.ListAccountsPages(&organizations.ListAccountsInput{},
+			func(out *organizations.ListAccountsOutput, lastPage bool) bool {
+				collect(out.Accounts)
+				return true
+			})
+		if err != nil {
+			return accounts, err
+		}
+	}
+	for _, parent := range d.ParentIDs {
+		err = client.ListAccountsForParentPages(&organizations.ListAccountsForParentInput{ParentId: aws.String(parent)},
+			func(out *organizations.ListAccountsForParentOutput, lastPage bool) bool {
+				collect(out.Accounts)
+				return true
+			})
+		if err != nil {
+			return accounts, err
+		}
+	}
+	seen := make(map[string]bool)
+	for _, acct := range found {
+		id := aws.StringValue(acct.Id)
+		if seen[id] || containsString(d.ExcludeAccounts, id) {
+			continue
+		}
+		seen[id] = true
+		if aws.StringValue(acct.Status) != organizations.AccountStatusActive {
+			goslogger.Loggo.Debug("skipping inactive account", "account", id, "status", aws.StringValue(acct.Status))
+			continue
+		}
+		if len(d.AccountTags) > 0 {
+			ok, err := d.matchesTags(client, id)
+			if err != nil {
+				return accounts, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		accounts = append(accounts, acct)
+	}
+	return accounts, err
+}
+
+// matchesTags returns true if the account has every tag in AccountTags
+func (d *OrganizationsDiscovery) matchesTags(client organizationsiface.OrganizationsAPI, account string) (ok bool, err error) {
+	tags := make(map[string]string)
+	err = client.ListTagsForResourcePages(&organizations.ListTagsForResourceInput{ResourceId: aws.String(account)},
+		func(out *organizations.ListTagsForResourceOutput, lastPage bool) bool {
+			for _, tag := range out.Tags {
+				tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			return true
+		})
+	if err != nil {
+		return ok, err
+	}
+	for k, v := range d.AccountTags {
+		if got, found := tags[k]; !found || got != v {
+			return false, err
+		}
+	}
+	return true, err
+}
+
+// discover lists the accounts and adds a mapping for each to the
+// assumptions. Account names are remembered so profile name templates
+// can use them.
+func (a *Assumptions) discoverOrganizationAccounts(client organizationsiface.OrganizationsAPI) (err error) {
+	d := a.Organizations
+	accounts, err := d.listAccounts(client)
+	if err != nil {
+		msg := fmt.Sprintf("unable to discover accounts from organizations: %s", err)
+		err = errors.New(msg)
+		return err
+	}
+	if len(accounts) < 1 {
+		goslogger.Loggo.Warn("organizations discovery found no accounts", "flow", a.parentFlow.Name, "roleName", d.RoleName)
+		return err
+	}
+	var ids []string
+	for _, acct := range accounts {
+		id := aws.StringValue(acct.Id)
+		a.parentConfig.setAccountName(id, aws.StringValue(acct.Name))
+		ids = append(ids, id)
+	}
+	mm := MatrixMapping{
+		RoleName:            d.RoleName,
+		Path:                d.Path,
+		Accounts:            ids,
+		ProfileNameTemplate: d.ProfileNameTemplate,
+		Mapping:             d.Mapping,
+	}
+	mappings, err := mm.expand(a, a.parentFlow, a.parentConfig)
+	if err != nil {
+		return err
+	}
+	for i := range mappings {
+		err = mappings[i].loadSessionPolicy()
+		if err != nil {
+			return err
+		}
+	}
+	goslogger.Loggo.Info("discovered accounts from organizations", "flow", a.parentFlow.Name, "roleName", d.RoleName, "count", len(mappings))
+	// discovered names can collide with ones from the config
+	return a.addDiscoveredMappings(mappings)
+}
+
+// discoverPrimaryAccounts runs organizations discovery for the primary
+// assumptions using the flow's starting credentials
+func (f *Flow) discoverPrimaryAccounts() (err error) {
+	if f.PAss == nil || f.PAss.Organizations == nil {
+		return err
+	}
+	sess, err := f.getPermSession()
+	if err != nil {
+		return err
+	}
+	return f.PAss.discoverOrganizationAccounts(newOrganizationsClient(sess))
+}
+
+// discoverSecondaryAccounts runs organizations discovery for the secondary
+// assumptions using the credentials of the primary mapping named by the
+// discovery's sponsor (or the only primary mapping)
+func (f *Flow) discoverSecondaryAccounts() (err error) {
+	if f.SAss == nil || f.SAss.Organizations == nil {
+		return err
+	}
+	sponsor := f.organizationsSponsor()
+	if sponsor == nil {
+		msg := fmt.Sprintf("organizations discovery needs a sponsor from the primary assumptions but '%s' wasn't found",
+			f.SAss.Organizations.sponsorRef())
+		err = errors.New(msg)
+		return err
+	}
+	cred, err := sponsor.getCredential()
+	if err != nil {
+		return err
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{Credentials: convertSCredsToCreds(cred)},
+	})
+	if err != nil {
+		return err
+	}
+	return f.SAss.discoverOrganizationAccounts(newOrganizationsClient(sess))
+}
+
+// organizationsSponsor returns the primary mapping whose credentials are
+// used for discovery under the secondary assumptions
+func (f *Flow) organizationsSponsor() *Mapping {
+	ref := f.SAss.Organizations.sponsorRef()
+	switch {
+	case len(ref) > 0:
+		return f.PAss.findMappingByRef(ref)
+	case f.PAss != nil && len(f.PAss.Mappings) == 1:
+		return &f.PAss.Mappings[0]
+	}
+	return nil
+}
+
+// newOrganizationsClient returns an Organizations client for the session.
+// Organizations only has an endpoint in us-east-1 for the commercial
+// partition so that's used if the session has no region.
+func newOrganizationsClient(sess *session.Session) *organizations.Organizations {
+	if len(aws.StringValue(sess.Config.Region)) < 1 {
+		return organizations.New(sess, aws.NewConfig().WithRegion(organizationsDefaultRegion))
+	}
+	return organizations.New(sess)
+}
@@ -0,0 +1,184 @@
+package gossamer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/organizations"
+)
+
+type fakeOrgAccount struct {
+	Id     string
+	Name   string
+	Status string
+	Parent string            `json:"-"`
+	Tags   map[string]string `json:"-"`
+}
+
+// newFakeOrganizations starts a local endpoint that answers the
+// Organizations calls used by discovery one account per page so
+// that paging gets exercised
+func newFakeOrganizations(accounts []fakeOrgAccount) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			NextToken  string
+			ParentId   string
+			ResourceId string
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		target := r.Header.Get("X-Amz-Target")
+		switch {
+		case strings.HasSuffix(target, ".ListTagsForResource"):
+			var tags []map[string]string
+			for _, a := range accounts {
+				if a.Id == in.ResourceId {
+					for k, v := range a.Tags {
+						tags = append(tags, map[string]string{"Key": k, "Value": v})
+					}
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"Tags": tags})
+		case strings.HasSuffix(target, ".ListAccounts"), strings.HasSuffix(target, ".ListAccountsForParent"):
+			var matched []fakeOrgAccount
+			for _, a := range accounts {
+				if len(in.ParentId) < 1 || a.Parent == in.ParentId {
+					matched = append(matched, a)
+				}
+			}
+			out := map[string]interface{}{"Accounts": []fakeOrgAccount{}}
+			i := 0
+			fmt.Sscanf(in.NextToken, "%d", &i)
+			if i < len(matched) {
+				out["Accounts"] = matched[i : i+1]
+				if i+1 < len(matched) {
+					out["NextToken"] = fmt.Sprintf("%d", i+1)
+				}
+			}
+			json.NewEncoder(w).Encode(out)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"__type":"InvalidInputException","message":"unexpected target %s"}`, target)
+		}
+	}))
+}
+
+func TestDiscoverOrganizationAccounts(t *testing.T) {
+	initLog()
+	srv := newFakeOrganizations([]fakeOrgAccount{
+		{Id: "111111111111", Name: "Management", Status: "ACTIVE", Parent: "r-root"},
+		{Id: "222222222222", Name: "Prod Payments", Status: "ACTIVE", Parent: "ou-prod", Tags: map[string]string{"env": "prod"}},
+		{Id: "333333333333", Name: "Old Prod", Status: "SUSPENDED", Parent: "ou-prod", Tags: map[string]string{"env": "prod"}},
+		{Id: "444444444444", Name: "Prod Search", Status: "ACTIVE", Parent: "ou-prod", Tags: map[string]string{"env": "prod"}},
+		{Id: "555555555555", Name: "Dev", Status: "ACTIVE", Parent: "ou-dev", Tags: map[string]string{"env": "dev"}},
+	})
+	defer srv.Close()
+	client := organizations.New(session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(srv.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("AKIAFAKE", "secret", ""),
+	})))
+	cases := []struct {
+		discovery OrganizationsDiscovery
+		template  string
+		expected  []string
+		fails     bool
+	}{
+		{
+			discovery: OrganizationsDiscovery{RoleName: "OrganizationAccountAccessRole", ExcludeAccounts: []string{"111111111111"}},
+			expected: []string{
+				"arn:aws:iam::222222222222:role/OrganizationAccountAccessRole=prod-payments",
+				"arn:aws:iam::444444444444:role/OrganizationAccountAccessRole=prod-search",
+				"arn:aws:iam::555555555555:role/OrganizationAccountAccessRole=dev",
+			},
+		},
+		{
+			discovery: OrganizationsDiscovery{RoleName: "readonly", Path: "/ops/", ParentIDs: []string{"ou-prod", "ou-dev"}, AccountTags: map[string]string{"env": "prod"}},
+			expected: []string{
+				"arn:aws:iam::222222222222:role/ops/readonly=prod-payments",
+				"arn:aws:iam::444444444444:role/ops/readonly=prod-search",
+			},
+		},
+		{
+			discovery: OrganizationsDiscovery{RoleName: "readonly", ParentIDs: []string{"ou-empty"}},
+		},
+		{
+			// collides with the primary so nothing is added
+			discovery: OrganizationsDiscovery{RoleName: "readonly", ParentIDs: []string{"ou-dev"}},
+			template:  "hub",
+			fails:     true,
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := newTestChainFlow([]Mapping{{RoleArn: "arn:aws:iam::111111111111:role/hub", ProfileName: "hub"}}, nil)
+		f.parentConfig.ProfileNameNormalization = &ProfileNameNormalization{Case: "lower", Separator: "-"}
+		d := c.discovery
+		d.ProfileNameTemplate = "{{.AccountName}}"
+		if len(c.template) > 0 {
+			d.ProfileNameTemplate = c.template
+		}
+		d.Sponsor = "hub"
+		f.SAss.Organizations = &d
+		err := f.SAss.discoverOrganizationAccounts(client)
+		if c.fails {
+			if err == nil || len(f.SAss.Mappings) > 0 {
+				t.Errorf("expected collision to fail without adding mappings but got %v, %d mappings", err, len(f.SAss.Mappings))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		var got []string
+		for _, m := range f.SAss.Mappings {
+			got = append(got, m.RoleArn+"="+m.ProfileName)
+			if m.Sponsor != "hub" || m.parentAssumptions != f.SAss {
+				t.Errorf("expected discovered mapping to keep settings and relationships: %+v", m)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("unexpected mappings:\nwant %v\ngot  %v", c.expected, got)
+		}
+	}
+}
+
+func TestOrganizationsDiscoveryValidate(t *testing.T) {
+	initLog()
+	f := newTestChainFlow(nil, nil)
+	cases := []struct {
+		discovery   *OrganizationsDiscovery
+		errContains string
+	}{
+		{discovery: nil},
+		{discovery: &OrganizationsDiscovery{RoleName: "admin"}},
+		{discovery: &OrganizationsDiscovery{}, errContains: "role_name '' must be set"},
+		{discovery: &OrganizationsDiscovery{RoleName: "admin", Mapping: Mapping{ProfileName: "x"}}, errContains: "can't set role_arn or profile_name"},
+		{discovery: &OrganizationsDiscovery{RoleName: "admin", ProfileNameTemplate: "{{.Oops"}, errContains: "error parsing"},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		err := c.discovery.validate(f.SAss)
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("expected error containing '%s' but got '%v'", c.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+	f.SAMLConfig = &SAMLConfig{}
+	if err := (&OrganizationsDiscovery{RoleName: "admin"}).validate(f.PAss); err == nil {
+		t.Error("expected error for discovery on SAML primary assumptions")
+	}
+}
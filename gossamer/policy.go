@@ -0,0 +1,147 @@
+package gossamer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"gopkg.in/yaml.v2"
+)
+
+// limits on session policies documented for AssumeRole
+// and AssumeRoleWithSAML
+const (
+	maxSessionPolicyLength = 2048
+	maxSessionPolicyArns   = 10
+)
+
+var policyArnRegex = regexp.MustCompile(`^arn:[\w-]+:iam::(aws|\d{12}):policy/.+$`)
+
+// loadSessionPolicy builds the JSON for the mapping's inline session
+// policy from either policy or policy_file and checks the policy and
+// policy ARNs against the STS limits so problems show up before any
+// calls are made.
+func (m *Mapping) loadSessionPolicy() (err error) {
+	m.policyJSON = ""
+	if m.Policy != nil && len(m.PolicyFile) > 0 {
+		msg := fmt.Sprintf("mapping '%s' can only have one of policy or policy_file", m.RoleArn)
+		err = errors.New(msg)
+		return err
+	}
+	switch {
+	case len(m.PolicyFile) > 0:
+		var data []byte
+		data, err = ioutil.ReadFile(m.PolicyFile)
+		if err != nil {
+			return err
+		}
+		var doc interface{}
+		// JSON is valid YAML so this handles both
+		err = yaml.Unmarshal(data, &doc)
+		if err != nil {
+			msg := fmt.Sprintf("unable to parse policy_file '%s' for mapping '%s': %s", m.PolicyFile, m.RoleArn, err)
+			err = errors.New(msg)
+			return err
+		}
+		m.policyJSON, err = policyToJSON(doc)
+	case m.Policy != nil:
+		m.policyJSON, err = policyToJSON(m.Policy)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("invalid session policy for mapping '%s': %s", m.RoleArn, err)
+		err = errors.New(msg)
+		return err
+	}
+	if len(m.policyJSON) > maxSessionPolicyLength {
+		msg := fmt.Sprintf("session policy for mapping '%s' is %d characters once minified which is over the %d character limit",
+			m.RoleArn, len(m.policyJSON), maxSessionPolicyLength)
+		err = errors.New(msg)
+		return err
+	}
+	if len(m.PolicyArns) > maxSessionPolicyArns {
+		msg := fmt.Sprintf("mapping '%s' has %d policy_arns but at most %d are allowed", m.RoleArn, len(m.PolicyArns), maxSessionPolicyArns)
+		err = errors.New(msg)
+		return err
+	}
+	for _, arn := range m.PolicyArns {
+		if !policyArnRegex.MatchString(arn) {
+			msg := fmt.Sprintf("policy_arns entry '%s' for mapping '%s' is not a managed policy ARN", arn, m.RoleArn)
+			err = errors.New(msg)
+			return err
+		}
+	}
+	return err
+}
+
+// loadSessionPolicies loads and checks the session policies of every mapping
+func (a *Assumptions) loadSessionPolicies() (err error) {
+	for i := range a.Mappings {
+		err = a.Mappings[i].loadSessionPolicy()
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// policyToJSON converts a policy document given either as a JSON string
+// or as YAML into minified JSON
+func policyToJSON(doc interface{}) (out string, err error) {
+	var buf bytes.Buffer
+	if s, ok := doc.(string); ok {
+		err = json.Compact(&buf, []byte(strings.TrimSpace(s)))
+		if err != nil {
+			return out, err
+		}
+		return buf.String(), err
+	}
+	raw, err := json.Marshal(jsonCompatible(doc))
+	if err != nil {
+		return out, err
+	}
+	return string(raw), err
+}
+
+// jsonCompatible converts the map[interface{}]interface{} values that
+// yaml.v2 produces into map[string]interface{} so they can be marshalled
+func jsonCompatible(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for k, val := range t {
+			m[fmt.Sprintf("%v", k)] = jsonCompatible(val)
+		}
+		return m
+	case []interface{}:
+		for i := range t {
+			t[i] = jsonCompatible(t[i])
+		}
+		return t
+	}
+	return v
+}
+
+// getPolicyDescriptors returns the mapping's policy ARNs in the form STS wants
+func (m *Mapping) getPolicyDescriptors() (pds []*sts.PolicyDescriptorType) {
+	for _, arn := range m.PolicyArns {
+		pds = append(pds, &sts.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+	return pds
+}
+
+// detectedPackedPolicyProblem wraps the error STS returns when the session
+// policies and tags are too big once packed with one that says what to do
+func detectedPackedPolicyProblem(roleArn *string, err error) error {
+	if err != nil && strings.Contains(err.Error(), sts.ErrCodePackedPolicyTooLargeException) {
+		msg := fmt.Sprintf("session policies and tags for '%s' are too large once packed by AWS, try fewer policy_arns, a smaller policy, or fewer session tags: %s",
+			aws.StringValue(roleArn), err)
+		return errors.New(msg)
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package gossamer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"gopkg.in/yaml.v2"
+)
+
+func TestLoadSessionPolicy(t *testing.T) {
+	initLog()
+	dir, err := ioutil.TempDir("", "gossamer-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	policyFile := filepath.Join(dir, "policy.json")
+	err = ioutil.WriteFile(policyFile, []byte(`{
+    "Version": "2012-10-17",
+    "Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}]
+}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Statement":[{"Action":"s3:GetObject","Effect":"Allow","Resource":"*"}],"Version":"2012-10-17"}`
+	var manyArns []string
+	for i := 0; i < 11; i++ {
+		manyArns = append(manyArns, "arn:aws:iam::aws:policy/ReadOnlyAccess")
+	}
+	cases := []struct {
+		yaml        string
+		mapping     Mapping
+		policyJSON  string
+		errContains string
+	}{
+		{
+			yaml: `
+role_arn: arn:aws:iam::123456789012:role/admin
+policy_arns: [arn:aws:iam::aws:policy/ReadOnlyAccess]
+policy:
+  Version: "2012-10-17"
+  Statement:
+  - Effect: Allow
+    Action: s3:GetObject
+    Resource: "*"
+`,
+			policyJSON: want,
+		},
+		{
+			yaml: `
+role_arn: arn:aws:iam::123456789012:role/admin
+policy: '{"Statement": [{"Action": "s3:GetObject", "Effect": "Allow", "Resource": "*"}], "Version": "2012-10-17"}'
+`,
+			policyJSON: want,
+		},
+		{
+			mapping:    Mapping{PolicyFile: policyFile},
+			policyJSON: want,
+		},
+		{
+			mapping:     Mapping{PolicyFile: policyFile, Policy: "{}"},
+			errContains: "only have one of policy or policy_file",
+		},
+		{
+			mapping:     Mapping{Policy: "{not json"},
+			errContains: "invalid session policy",
+		},
+		{
+			mapping:     Mapping{Policy: `{"Sid": "` + strings.Repeat("a", maxSessionPolicyLength) + `"}`},
+			errContains: "character limit",
+		},
+		{
+			mapping:     Mapping{PolicyArns: manyArns},
+			errContains: "at most 10 are allowed",
+		},
+		{
+			mapping:     Mapping{PolicyArns: []string{"arn:aws:iam::123456789012:role/nope"}},
+			errContains: "not a managed policy ARN",
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		m := c.mapping
+		if len(c.yaml) > 0 {
+			err = yaml.Unmarshal([]byte(c.yaml), &m)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = m.loadSessionPolicy()
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("expected error containing '%s' but got '%v'", c.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if m.policyJSON != c.policyJSON {
+			t.Errorf("unexpected policy: want '%s', got '%s'", c.policyJSON, m.policyJSON)
+		}
+		sa := &sessionAttributes{}
+		sa.setSessionPolicy(&m)
+		input := sts.AssumeRoleWithSAMLInput{}
+		sa.applySAML(&input)
+		if aws.StringValue(input.Policy) != c.policyJSON || len(input.PolicyArns) != len(m.PolicyArns) {
+			t.Errorf("session policy not applied to SAML input: %v", input)
+		}
+	}
+}
+
+func TestDetectedPackedPolicyProblem(t *testing.T) {
+	err := detectedPackedPolicyProblem(aws.String("arn:aws:iam::123456789012:role/admin"), errors.New("PackedPolicyTooLarge: too big"))
+	if err == nil || !strings.Contains(err.Error(), "try fewer policy_arns") {
+		t.Errorf("expected friendlier error but got '%v'", err)
+	}
+	other := errors.New("AccessDenied")
+	if detectedPackedPolicyProblem(nil, other) != other {
+		t.Error("expected other errors to pass through untouched")
+	}
+}
@@ -0,0 +1,250 @@
+package gossamer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+)
+
+// ProfileNameNormalization cleans up generated profile names so they're
+// consistent no matter how the accounts and roles were named
+type ProfileNameNormalization struct {
+	// Case is 'lower', 'upper' or blank to leave it alone
+	Case string `yaml:"case,omitempty"`
+	// Separator replaces every run of characters other than letters and digits
+	Separator string `yaml:"separator,omitempty"`
+}
+
+var profileNameSeparatorRegex = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+func (n *ProfileNameNormalization) validate() (err error) {
+	if n == nil {
+		return err
+	}
+	switch n.Case {
+	case "", "lower", "upper":
+	default:
+		msg := fmt.Sprintf("profile_name_normalization case must be 'lower', 'upper' or blank but got '%s'", n.Case)
+		err = errors.New(msg)
+	}
+	return err
+}
+
+// apply normalizes the name. A nil normalization leaves it as is.
+func (n *ProfileNameNormalization) apply(name string) string {
+	if n == nil {
+		return name
+	}
+	if len(n.Separator) > 0 {
+		name = profileNameSeparatorRegex.ReplaceAllString(name, n.Separator)
+		name = strings.Trim(name, n.Separator)
+	}
+	switch n.Case {
+	case "lower":
+		name = strings.ToLower(name)
+	case "upper":
+		name = strings.ToUpper(name)
+	}
+	return name
+}
+
+// parseProfileNameTemplate parses a profile_name_template failing
+// on any reference to an unknown variable
+func parseProfileNameTemplate(tmpl string) (t *template.Template, err error) {
+	t, err = template.New("profile_name").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		msg := fmt.Sprintf("error parsing profile_name_template '%s': %s", tmpl, err)
+		err = errors.New(msg)
+	}
+	return t, err
+}
+
+// loadAccountAliases reads the account_alias_file if there is one
+func (gc *Config) loadAccountAliases() (err error) {
+	if len(gc.AccountAliasFile) < 1 {
+		return err
+	}
+	gc.fileAccountAliases, err = loadAccountAliasFile(gc.AccountAliasFile)
+	if err != nil {
+		msg := fmt.Sprintf("unable to load account_alias_file '%s': %s", gc.AccountAliasFile, err)
+		err = errors.New(msg)
+	}
+	return err
+}
+
+// getAccountAlias returns the alias for the account from account_aliases,
+// the account_alias_file or the aliases resolved from AWS in that order
+func (gc *Config) getAccountAlias(account string) (alias string, ok bool) {
+	if gc == nil {
+		return alias, ok
+	}
+	if alias, ok = gc.AccountAliases[account]; ok && len(alias) > 0 {
+		return alias, ok
+	}
+	if alias, ok = gc.fileAccountAliases[account]; ok && len(alias) > 0 {
+		return alias, ok
+	}
+	alias, ok = gc.getResolvedAccountAlias(account)
+	return alias, ok && len(alias) > 0
+}
+
+// setAccountName remembers the name of an account found in Organizations
+func (gc *Config) setAccountName(account, name string) {
+	if gc == nil || len(name) < 1 {
+		return
+	}
+	gc.aliasMu.Lock()
+	defer gc.aliasMu.Unlock()
+	if gc.accountNames == nil {
+		gc.accountNames = make(map[string]string)
+	}
+	gc.accountNames[account] = name
+}
+
+// getAccountName returns the name of an account found in Organizations
+func (gc *Config) getAccountName(account string) (name string, ok bool) {
+	if gc == nil {
+		return name, ok
+	}
+	gc.aliasMu.Lock()
+	defer gc.aliasMu.Unlock()
+	name, ok = gc.accountNames[account]
+	return name, ok
+}
+
+// getProfileNameTemplate returns the closest profile_name_template
+// looking at the mapping's assumptions, flow and then config
+func (m *Mapping) getProfileNameTemplate() string {
+	if m.parentAssumptions != nil && len(m.parentAssumptions.ProfileNameTemplate) > 0 {
+		return m.parentAssumptions.ProfileNameTemplate
+	}
+	if m.parentFlow != nil && len(m.parentFlow.ProfileNameTemplate) > 0 {
+		return m.parentFlow.ProfileNameTemplate
+	}
+	if m.parentConfig != nil {
+		return m.parentConfig.ProfileNameTemplate
+	}
+	return ""
+}
+
+// getProfileNameNormalization returns the closest profile_name_normalization
+// looking at the mapping's assumptions, flow and then config
+func (m *Mapping) getProfileNameNormalization() *ProfileNameNormalization {
+	if m.parentAssumptions != nil && m.parentAssumptions.ProfileNameNormalization != nil {
+		return m.parentAssumptions.ProfileNameNormalization
+	}
+	if m.parentFlow != nil && m.parentFlow.ProfileNameNormalization != nil {
+		return m.parentFlow.ProfileNameNormalization
+	}
+	if m.parentConfig != nil {
+		return m.parentConfig.ProfileNameNormalization
+	}
+	return nil
+}
+
+// inheritedRegion returns the region the mapping will end up with which
+// may come from the flow if the mapping doesn't set one itself
+func (m *Mapping) inheritedRegion() string {
+	if len(m.Region) > 0 || m.parentFlow == nil || m.parentFlow.DoNotPropagateRegion {
+		return m.Region
+	}
+	return m.parentFlow.Region
+}
+
+// generateProfileName builds the profile name for a mapping that doesn't
+// have one using the closest profile_name_template or the usual
+// '<account>_<role>' format and then normalizes it
+func (m *Mapping) generateProfileName() (name string, err error) {
+	tmpl := m.getProfileNameTemplate()
+	if len(tmpl) > 0 {
+		return m.renderProfileName(tmpl)
+	}
+	uid, err := getRoleUniqueID(m.RoleArn)
+	if err != nil {
+		return name, err
+	}
+	return m.getProfileNameNormalization().apply(*uid), err
+}
+
+// renderProfileName renders the given profile name template for the
+// mapping and normalizes the result
+func (m *Mapping) renderProfileName(tmpl string) (name string, err error) {
+	t, err := parseProfileNameTemplate(tmpl)
+	if err != nil {
+		return name, err
+	}
+	flowName := ""
+	if m.parentFlow != nil {
+		flowName = m.parentFlow.Name
+	}
+	data, err := newProfileNameData(m.RoleArn, m.inheritedRegion(), flowName, m.parentConfig)
+	if err != nil {
+		return name, err
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		msg := fmt.Sprintf("error rendering profile_name_template '%s' for '%s': %s", tmpl, m.RoleArn, err)
+		err = errors.New(msg)
+		return name, err
+	}
+	name = m.getProfileNameNormalization().apply(buf.String())
+	if len(name) < 1 {
+		msg := fmt.Sprintf("profile_name_template '%s' gave a blank profile name for '%s'", tmpl, m.RoleArn)
+		err = errors.New(msg)
+	}
+	return name, err
+}
+
+// validateProfileNames makes sure the profile name templates and
+// normalizations that apply to the flow work for its mappings
+func (f *Flow) validateProfileNames() (err error) {
+	templates := []string{f.ProfileNameTemplate}
+	normalizations := []*ProfileNameNormalization{f.ProfileNameNormalization}
+	if f.parentConfig != nil {
+		templates = append(templates, f.parentConfig.ProfileNameTemplate)
+		normalizations = append(normalizations, f.parentConfig.ProfileNameNormalization)
+	}
+	for _, a := range []*Assumptions{f.PAss, f.SAss} {
+		if a != nil {
+			templates = append(templates, a.ProfileNameTemplate)
+			normalizations = append(normalizations, a.ProfileNameNormalization)
+		}
+	}
+	for _, tmpl := range templates {
+		if len(tmpl) > 0 {
+			_, err = parseProfileNameTemplate(tmpl)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, n := range normalizations {
+		err = n.validate()
+		if err != nil {
+			return err
+		}
+	}
+	for _, a := range []*Assumptions{f.PAss, f.SAss} {
+		if a == nil {
+			continue
+		}
+		for i := range a.Mappings {
+			m := &a.Mappings[i]
+			if len(m.ProfileName) > 0 {
+				continue
+			}
+			name, err := m.generateProfileName()
+			if err != nil {
+				return err
+			}
+			goslogger.Loggo.Debug("mapping will use generated profile name", "roleArn", m.RoleArn, "profileName", name)
+		}
+	}
+	return err
+}
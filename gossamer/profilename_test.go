@@ -0,0 +1,125 @@
+package gossamer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateProfileName(t *testing.T) {
+	initLog()
+	admin := "arn:aws:iam::123456789012:role/ops/Admin_Role"
+	cases := []struct {
+		configTemplate string
+		flowTemplate   string
+		assTemplate    string
+		normalization  *ProfileNameNormalization
+		region         string
+		expected       string
+		errContains    string
+	}{
+		{
+			expected: "123456789012_ops/Admin_Role",
+		},
+		{
+			configTemplate: "{{.AccountAlias}}-{{.RoleName}}",
+			expected:       "prod-Admin_Role",
+		},
+		{
+			// the closest template wins
+			configTemplate: "{{.AccountAlias}}-{{.RoleName}}",
+			flowTemplate:   "{{.FlowName}}-{{.RoleName}}",
+			assTemplate:    "{{.AccountNumber}}{{.RolePath}}{{.RoleName}}-{{.Region}}",
+			region:         "us-east-1",
+			expected:       "123456789012/ops/Admin_Role-us-east-1",
+		},
+		{
+			flowTemplate:  "{{.AccountAlias}} {{.RolePath}}{{.RoleName}}",
+			normalization: &ProfileNameNormalization{Case: "lower", Separator: "-"},
+			expected:      "prod-ops-admin-role",
+		},
+		{
+			normalization: &ProfileNameNormalization{Case: "upper", Separator: "_"},
+			expected:      "123456789012_OPS_ADMIN_ROLE",
+		},
+		{
+			flowTemplate: "{{.Nope}}",
+			errContains:  "error rendering profile_name_template",
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := newTestChainFlow([]Mapping{{RoleArn: admin}}, nil)
+		f.parentConfig.ProfileNameTemplate = c.configTemplate
+		f.parentConfig.ProfileNameNormalization = c.normalization
+		f.parentConfig.AccountAliases = map[string]string{"123456789012": "prod"}
+		f.ProfileNameTemplate = c.flowTemplate
+		f.PAss.ProfileNameTemplate = c.assTemplate
+		f.Region = c.region
+		name, err := f.PAss.Mappings[0].generateProfileName()
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("expected error containing '%s' but got '%v'", c.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if name != c.expected {
+			t.Errorf("unexpected profile name: want '%s', got '%s'", c.expected, name)
+		}
+	}
+}
+
+func TestGetAccountAlias(t *testing.T) {
+	initLog()
+	dir, err := ioutil.TempDir("", "gossamer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "aliases.csv")
+	if err := ioutil.WriteFile(fn, []byte("123456789012,prod-from-file\n210987654321,dev\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gc := Config{
+		AccountAliases:   map[string]string{"123456789012": "prod"},
+		AccountAliasFile: fn,
+	}
+	if err := gc.loadAccountAliases(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cases := map[string]string{
+		"123456789012": "prod",
+		"210987654321": "dev",
+		"999999999999": "",
+	}
+	for account, expected := range cases {
+		if alias, _ := gc.getAccountAlias(account); alias != expected {
+			t.Errorf("unexpected alias for '%s': want '%s', got '%s'", account, expected, alias)
+		}
+	}
+	var nilConfig *Config
+	if _, ok := nilConfig.getAccountAlias("123456789012"); ok {
+		t.Error("expected nil config to have no aliases")
+	}
+}
+
+func TestValidateProfileNames(t *testing.T) {
+	initLog()
+	f := newTestChainFlow([]Mapping{{RoleArn: "arn:aws:iam::123456789012:role/admin"}}, nil)
+	f.ProfileNameNormalization = &ProfileNameNormalization{Case: "title"}
+	if err := f.validateProfileNames(); err == nil || !strings.Contains(err.Error(), "case must be") {
+		t.Errorf("expected case error but got '%v'", err)
+	}
+	f.ProfileNameNormalization = nil
+	f.parentConfig.ProfileNameTemplate = "{{.RoleName"
+	if err := f.validateProfileNames(); err == nil || !strings.Contains(err.Error(), "error parsing") {
+		t.Errorf("expected parse error but got '%v'", err)
+	}
+}
@@ -0,0 +1,148 @@
+package gossamer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// profileEntryTemplate is acfmgr's default entry with the expiration in a
+// key the AWS CLI and SDKs read and any extra settings for the mapping
+const profileEntryTemplate = `# DO NOT EDIT
+# ACFMGR MANAGED SECTION
+# (Will be overwritten regularly)
+####################################################
+# ASSUMED ROLE: {{.AssumeRoleARN}}
+# ASSUMED FROM INSTANCE ROLE: {{.InstanceRoleARN}}
+# GENERATED: {{.Generated}}
+{{ .ExpiresToken }}   {{.Expiration}}
+{{- if .HasDescription}}
+# DESCRIPTION: {{.Description}}{{end}}
+{{- if .HasRegion}}
+region = {{.Region}}{{end}}
+{{- if .HasOutput}}
+output = {{.OutputFormat}}{{end}}
+aws_access_key_id = {{.AccessKeyID}}
+aws_secret_access_key = {{.SecretAccessKey}}
+aws_session_token = {{.SessionToken}}
+{{- with credentialExpiration }}
+aws_credential_expiration = {{ . }}{{ end }}
+{{- range extraSettings }}
+{{ . }}{{ end }}
+`
+
+// reservedProfileKeys are written by gossamer and can't be set as extras
+var reservedProfileKeys = []string{
+	"aws_access_key_id",
+	"aws_secret_access_key",
+	"aws_session_token",
+	"aws_credential_expiration",
+	"region",
+	"output",
+	"cli_pager",
+}
+
+var profileKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)?$`)
+
+// validateProfileSettings checks the extra settings that will be
+// written to the mapping's profile entries
+func (m *Mapping) validateProfileSettings() (err error) {
+	if strings.ContainsAny(m.Output, "\r\n") || (m.CLIPager != nil && strings.ContainsAny(*m.CLIPager, "\r\n")) {
+		msg := fmt.Sprintf("output and cli_pager for mapping '%s' can't contain newlines", m.RoleArn)
+		err = errors.New(msg)
+		return err
+	}
+	for k, v := range m.Extra {
+		if !profileKeyRegex.MatchString(k) {
+			msg := fmt.Sprintf("extra setting '%s' for mapping '%s' must match '%s'", k, m.RoleArn, profileKeyRegex)
+			err = errors.New(msg)
+			return err
+		}
+		if containsString(reservedProfileKeys, strings.ToLower(k)) {
+			msg := fmt.Sprintf("extra setting '%s' for mapping '%s' is written by gossamer and can't be set", k, m.RoleArn)
+			err = errors.New(msg)
+			return err
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			msg := fmt.Sprintf("extra setting '%s' for mapping '%s' can't contain newlines", k, m.RoleArn)
+			err = errors.New(msg)
+			return err
+		}
+	}
+	return err
+}
+
+// extraProfileLines returns the lines for cli_pager and the extra settings
+// in a stable order. Dotted keys (e.g., 's3.max_concurrent_requests') are
+// grouped under their section the way the AWS CLI expects nested settings
+// and come last so the simple settings aren't read as part of a section.
+func (m *Mapping) extraProfileLines() (lines []string) {
+	if m.CLIPager != nil {
+		lines = append(lines, strings.TrimSpace("cli_pager = "+*m.CLIPager))
+	}
+	var keys []string
+	for k := range m.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var nested []string
+	section := ""
+	for _, k := range keys {
+		parts := strings.SplitN(k, ".", 2)
+		if len(parts) < 2 {
+			lines = append(lines, fmt.Sprintf("%s = %s", k, m.Extra[k]))
+			continue
+		}
+		if parts[0] != section {
+			section = parts[0]
+			nested = append(nested, section+" =")
+		}
+		nested = append(nested, fmt.Sprintf("    %s = %s", parts[1], m.Extra[k]))
+	}
+	return append(lines, nested...)
+}
+
+// profileEntryTemplate returns the acfmgr template for the mapping's
+// entries with the credential's expiration and the extra settings
+func (m *Mapping) profileEntryTemplate(cred *sts.Credentials) (t *template.Template, err error) {
+	expiration := ""
+	if cred.Expiration != nil {
+		expiration = cred.Expiration.UTC().Format(time.RFC3339)
+	}
+	lines := m.extraProfileLines()
+	t, err = template.New("profile_entry").Funcs(template.FuncMap{
+		"credentialExpiration": func() string { return expiration },
+		"extraSettings":        func() []string { return lines },
+	}).Parse(profileEntryTemplate)
+	return t, err
+}
+
+// validateProfileSettings checks the profile settings of every mapping in
+// the flow including the ones that are copied onto discovered mappings
+func (f *Flow) validateProfileSettings() (err error) {
+	for _, a := range []*Assumptions{f.PAss, f.SAss} {
+		if a == nil {
+			continue
+		}
+		mappings := append([]Mapping{}, a.Mappings...)
+		if a.Organizations != nil {
+			mappings = append(mappings, a.Organizations.Mapping)
+		}
+		if a.DiscoverRoles != nil {
+			mappings = append(mappings, a.DiscoverRoles.Mapping)
+		}
+		for i := range mappings {
+			err = mappings[i].validateProfileSettings()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package gossamer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GESkunkworks/acfmgr"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func TestProfileEntrySettings(t *testing.T) {
+	initLog()
+	dir, err := ioutil.TempDir("", "gossamer-profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cred := &sts.Credentials{
+		AccessKeyId:     aws.String("ASIAFAKE"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Date(2021, 6, 1, 13, 4, 5, 0, time.FixedZone("EST", -5*3600))),
+	}
+	cases := []struct {
+		mapping  Mapping
+		expected []string
+		missing  []string
+	}{
+		{
+			mapping:  Mapping{RoleArn: "arn:aws:iam::123456789012:role/plain", ProfileName: "plain"},
+			expected: []string{"aws_session_token = token\naws_credential_expiration = 2021-06-01T18:04:05Z\n"},
+			missing:  []string{"output =", "cli_pager"},
+		},
+		{
+			mapping: Mapping{
+				RoleArn:     "arn:aws:iam::123456789012:role/rich",
+				ProfileName: "rich",
+				Region:      "us-east-1",
+				Output:      "json",
+				CLIPager:    aws.String(""),
+				Extra: map[string]string{
+					"s3.multipart_threshold":     "64MB",
+					"s3.max_concurrent_requests": "20",
+					"duration_seconds":           "3600",
+				},
+			},
+			expected: []string{
+				"region = us-east-1\noutput = json\naws_access_key_id",
+				"aws_credential_expiration = 2021-06-01T18:04:05Z\n" +
+					"cli_pager =\n" +
+					"duration_seconds = 3600\n" +
+					"s3 =\n" +
+					"    max_concurrent_requests = 20\n" +
+					"    multipart_threshold = 64MB\n",
+			},
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		filename := filepath.Join(dir, fmt.Sprintf("credentials-%d", i))
+		cf, err := acfmgr.NewCredFileSession(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl, err := c.mapping.profileEntryTemplate(cred)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		err = cf.NewEntry(&acfmgr.ProfileEntryInput{
+			Credential:       cred,
+			ProfileEntryName: c.mapping.ProfileName,
+			Region:           c.mapping.Region,
+			OutputFormat:     c.mapping.Output,
+			TemplateOverride: tmpl,
+		})
+		if err == nil {
+			err = cf.AssertEntries()
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range c.expected {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("expected entry to contain %q but got:\n%s", want, data)
+			}
+		}
+		for _, unwanted := range c.missing {
+			if strings.Contains(string(data), unwanted) {
+				t.Errorf("expected entry not to contain %q but got:\n%s", unwanted, data)
+			}
+		}
+	}
+}
+
+func TestValidateProfileSettings(t *testing.T) {
+	initLog()
+	cases := []struct {
+		mapping     Mapping
+		errContains string
+	}{
+		{mapping: Mapping{Output: "text", Extra: map[string]string{"s3.addressing_style": "path", "tcp_keepalive": "true"}}},
+		{mapping: Mapping{Extra: map[string]string{"aws_session_token": "x"}}, errContains: "written by gossamer"},
+		{mapping: Mapping{Extra: map[string]string{"Region": "us-east-1"}}, errContains: "written by gossamer"},
+		{mapping: Mapping{Extra: map[string]string{"bad key": "x"}}, errContains: "must match"},
+		{mapping: Mapping{Extra: map[string]string{"a.b.c": "x"}}, errContains: "must match"},
+		{mapping: Mapping{Extra: map[string]string{"ok": "x\n[default]"}}, errContains: "newlines"},
+		{mapping: Mapping{CLIPager: aws.String("less\n")}, errContains: "newlines"},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		err := c.mapping.validateProfileSettings()
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("expected error containing '%s' but got '%v'", c.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+}
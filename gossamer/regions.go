@@ -0,0 +1,93 @@
+package gossamer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"text/template"
+)
+
+// defaultRegionProfileNameTemplate names the entries written for
+// each region when no region_profile_name_template is set
+const defaultRegionProfileNameTemplate = "{{.ProfileName}}-{{.Region}}"
+
+var validRegionRegex = regexp.MustCompile(`\w{2}-([a-z]*-){1,2}\d{1}`)
+
+// profileEntry is a single profile written to the output file
+// for a mapping
+type profileEntry struct {
+	profileName string
+	region      string
+}
+
+// getRegions returns the mapping's regions or the flow's if it has none
+func (m *Mapping) getRegions() []string {
+	if len(m.Regions) > 0 || m.parentFlow == nil {
+		return m.Regions
+	}
+	return m.parentFlow.Regions
+}
+
+// getRegionProfileNameTemplate returns the mapping's template for
+// regional profile names falling back to the flow's and the default
+func (m *Mapping) getRegionProfileNameTemplate() string {
+	if len(m.RegionProfileNameTemplate) > 0 {
+		return m.RegionProfileNameTemplate
+	}
+	if m.parentFlow != nil && len(m.parentFlow.RegionProfileNameTemplate) > 0 {
+		return m.parentFlow.RegionProfileNameTemplate
+	}
+	return defaultRegionProfileNameTemplate
+}
+
+// profileEntries returns the profiles to write for the mapping. Mappings
+// with regions get one entry per region named by the region template and
+// the rest get a single entry with their own name and region.
+func (m *Mapping) profileEntries() (entries []profileEntry, err error) {
+	regions := m.getRegions()
+	if len(regions) < 1 {
+		entries = append(entries, profileEntry{profileName: m.mappingID(), region: m.Region})
+		return entries, err
+	}
+	tmpl := m.getRegionProfileNameTemplate()
+	t, err := template.New("region_profile_name").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		msg := fmt.Sprintf("error parsing region_profile_name_template '%s': %s", tmpl, err)
+		err = errors.New(msg)
+		return entries, err
+	}
+	flowName := ""
+	if m.parentFlow != nil {
+		flowName = m.parentFlow.Name
+	}
+	seen := make(map[string]bool)
+	for _, region := range regions {
+		if !validRegionRegex.MatchString(region) {
+			msg := fmt.Sprintf("region '%s' for mapping '%s' must match '%s'", region, m.mappingID(), validRegionRegex)
+			err = errors.New(msg)
+			return entries, err
+		}
+		data, err := newProfileNameData(m.RoleArn, region, flowName, m.parentConfig)
+		if err != nil {
+			return entries, err
+		}
+		data.ProfileName = m.mappingID()
+		var buf bytes.Buffer
+		err = t.Execute(&buf, data)
+		if err != nil {
+			msg := fmt.Sprintf("error rendering region_profile_name_template '%s': %s", tmpl, err)
+			err = errors.New(msg)
+			return entries, err
+		}
+		name := buf.String()
+		if seen[name] {
+			msg := fmt.Sprintf("region_profile_name_template '%s' gives the same name '%s' for more than one region of mapping '%s'", tmpl, name, m.mappingID())
+			err = errors.New(msg)
+			return entries, err
+		}
+		seen[name] = true
+		entries = append(entries, profileEntry{profileName: name, region: region})
+	}
+	return entries, err
+}
@@ -0,0 +1,87 @@
+package gossamer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestProfileEntries(t *testing.T) {
+	initLog()
+	viewer := "arn:aws:iam::123456789012:role/viewer"
+	cases := []struct {
+		flowRegions  []string
+		flowTemplate string
+		mapping      Mapping
+		expected     []profileEntry
+		errContains  string
+	}{
+		{
+			mapping:  Mapping{RoleArn: viewer, ProfileName: "viewer", Region: "us-west-2"},
+			expected: []profileEntry{{"viewer", "us-west-2"}},
+		},
+		{
+			mapping: Mapping{RoleArn: viewer, ProfileName: "viewer", Regions: []string{"us-east-1", "eu-west-1"}},
+			expected: []profileEntry{
+				{"viewer-us-east-1", "us-east-1"},
+				{"viewer-eu-west-1", "eu-west-1"},
+			},
+		},
+		{
+			// flow defaults apply to mappings without their own
+			flowRegions:  []string{"us-east-1"},
+			flowTemplate: "{{.AccountNumber}}-{{.RoleName}}-{{.Region}}",
+			mapping:      Mapping{RoleArn: viewer},
+			expected:     []profileEntry{{"123456789012-viewer-us-east-1", "us-east-1"}},
+		},
+		{
+			flowRegions: []string{"us-east-1"},
+			mapping:     Mapping{RoleArn: viewer, Regions: []string{"ap-southeast-2"}},
+			expected:    []profileEntry{{"123456789012_viewer-ap-southeast-2", "ap-southeast-2"}},
+		},
+		{
+			mapping:     Mapping{RoleArn: viewer, Regions: []string{"us-east-1", "useast"}},
+			errContains: "region 'useast'",
+		},
+		{
+			mapping:     Mapping{RoleArn: viewer, Regions: []string{"us-east-1", "eu-west-1"}, RegionProfileNameTemplate: "{{.ProfileName}}"},
+			errContains: "gives the same name",
+		},
+		{
+			mapping:     Mapping{RoleArn: viewer, Regions: []string{"us-east-1"}, RegionProfileNameTemplate: "{{.Nope}}"},
+			errContains: "error rendering",
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := newTestChainFlow([]Mapping{c.mapping}, nil)
+		f.Regions = c.flowRegions
+		f.RegionProfileNameTemplate = c.flowTemplate
+		entries, err := f.PAss.Mappings[0].profileEntries()
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("expected error containing '%s' but got '%v'", c.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if fmt.Sprint(entries) != fmt.Sprint(c.expected) {
+			t.Errorf("unexpected entries: want %v, got %v", c.expected, entries)
+		}
+	}
+}
+
+func TestValidateRegionalEntryCollisions(t *testing.T) {
+	initLog()
+	f := newTestChainFlow([]Mapping{
+		{RoleArn: "arn:aws:iam::123456789012:role/viewer", ProfileName: "viewer", Regions: []string{"us-east-1"}},
+		{RoleArn: "arn:aws:iam::123456789012:role/other", ProfileName: "viewer-us-east-1"},
+	}, nil)
+	err := f.validateMappingIDs()
+	if err == nil || !strings.Contains(err.Error(), "'viewer-us-east-1' would be written for both") {
+		t.Errorf("expected collision error but got '%v'", err)
+	}
+}
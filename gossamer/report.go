@@ -0,0 +1,151 @@
+package gossamer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+)
+
+// SAMLRoleReport describes everything a SAML assertion grants
+// without any roles having been assumed
+type SAMLRoleReport struct {
+	Flow              string            `json:"flow"`
+	Issuer            string            `json:"issuer,omitempty"`
+	RoleSessionName   string            `json:"role_session_name,omitempty"`
+	SessionDuration   string            `json:"session_duration,omitempty"`
+	SourceIdentity    string            `json:"source_identity,omitempty"`
+	PrincipalTags     map[string]string `json:"principal_tags,omitempty"`
+	TransitiveTagKeys []string          `json:"transitive_tag_keys,omitempty"`
+	NotBefore         *time.Time        `json:"not_before,omitempty"`
+	NotOnOrAfter      *time.Time        `json:"not_on_or_after,omitempty"`
+	Valid             bool              `json:"valid"`
+	Roles             []SAMLRoleEntry   `json:"roles"`
+}
+
+// SAMLRoleEntry describes a single role from a SAML assertion
+type SAMLRoleEntry struct {
+	AccountNumber string `json:"account_number"`
+	RoleName      string `json:"role_name"`
+	RoleArn       string `json:"role_arn"`
+	PrincipalArn  string `json:"principal_arn"`
+	Identifier    string `json:"identifier"`
+}
+
+// ListSAMLRoles logs in to the flow's SAML provider and reports on the
+// roles in the assertion. It never calls STS so nothing is assumed.
+func (f *Flow) ListSAMLRoles() (report *SAMLRoleReport, err error) {
+	if f.SAMLConfig == nil {
+		msg := fmt.Sprintf("flow '%s' is not a SAML flow", f.Name)
+		err = errors.New(msg)
+		return report, err
+	}
+	_, err = f.Validate()
+	if err != nil {
+		return report, err
+	}
+	sc, err := f.startSAMLSession()
+	if err != nil {
+		return report, err
+	}
+	report = sc.report(time.Now())
+	goslogger.Loggo.Debug("built SAML role report", "flow", f.Name, "roles", len(report.Roles))
+	return report, err
+}
+
+// report converts the decoded assertion into a SAMLRoleReport
+func (sc *samlSessionConfig) report(now time.Time) *SAMLRoleReport {
+	r := SAMLRoleReport{
+		Issuer:            sc.issuer,
+		PrincipalTags:     sc.principalTags,
+		TransitiveTagKeys: sc.transitiveTagKeys,
+		NotBefore:         sc.notBefore,
+		NotOnOrAfter:      sc.notOnOrAfter,
+		Valid:             true,
+	}
+	if sc.sessionName != nil {
+		r.Flow = *sc.sessionName
+	}
+	if sc.roleSessionName != nil {
+		r.RoleSessionName = *sc.roleSessionName
+	}
+	if sc.sessionDuration != nil {
+		r.SessionDuration = *sc.sessionDuration
+	}
+	if sc.sourceIdentity != nil {
+		r.SourceIdentity = *sc.sourceIdentity
+	}
+	if sc.notBefore != nil && now.Before(*sc.notBefore) {
+		r.Valid = false
+	}
+	if sc.notOnOrAfter != nil && !now.Before(*sc.notOnOrAfter) {
+		r.Valid = false
+	}
+	for _, role := range sc.roles {
+		r.Roles = append(r.Roles, SAMLRoleEntry{
+			AccountNumber: role.accountNumber,
+			RoleName:      role.roleName,
+			RoleArn:       role.roleArn,
+			PrincipalArn:  role.principalArn,
+			Identifier:    role.identifier,
+		})
+	}
+	sort.Slice(r.Roles, func(i, j int) bool {
+		if r.Roles[i].AccountNumber != r.Roles[j].AccountNumber {
+			return r.Roles[i].AccountNumber < r.Roles[j].AccountNumber
+		}
+		return r.Roles[i].RoleName < r.Roles[j].RoleName
+	})
+	return &r
+}
+
+// WriteJSON writes the report to w as indented JSON
+func (r *SAMLRoleReport) WriteJSON(w io.Writer) (err error) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(r)
+}
+
+// WriteTable writes the report to w as a human readable table
+func (r *SAMLRoleReport) WriteTable(w io.Writer) (err error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Flow:\t%s\n", r.Flow)
+	if len(r.Issuer) > 0 {
+		fmt.Fprintf(tw, "Issuer:\t%s\n", r.Issuer)
+	}
+	fmt.Fprintf(tw, "RoleSessionName:\t%s\n", r.RoleSessionName)
+	fmt.Fprintf(tw, "SessionDuration:\t%s\n", r.SessionDuration)
+	if len(r.SourceIdentity) > 0 {
+		fmt.Fprintf(tw, "SourceIdentity:\t%s\n", r.SourceIdentity)
+	}
+	if len(r.PrincipalTags) > 0 {
+		var tags []string
+		for k, v := range r.PrincipalTags {
+			tags = append(tags, fmt.Sprintf("%s=%s", k, v))
+		}
+		sort.Strings(tags)
+		fmt.Fprintf(tw, "PrincipalTags:\t%s\n", strings.Join(tags, ", "))
+	}
+	if len(r.TransitiveTagKeys) > 0 {
+		fmt.Fprintf(tw, "TransitiveTagKeys:\t%s\n", strings.Join(r.TransitiveTagKeys, ", "))
+	}
+	if r.NotBefore != nil {
+		fmt.Fprintf(tw, "NotBefore:\t%s\n", r.NotBefore.Format(time.RFC3339))
+	}
+	if r.NotOnOrAfter != nil {
+		fmt.Fprintf(tw, "NotOnOrAfter:\t%s\n", r.NotOnOrAfter.Format(time.RFC3339))
+	}
+	fmt.Fprintf(tw, "Valid:\t%t\n", r.Valid)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "ACCOUNT\tROLE NAME\tPRINCIPAL ARN\tIDENTIFIER")
+	for _, role := range r.Roles {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", role.AccountNumber, role.RoleName, role.PrincipalArn, role.Identifier)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,54 @@
+package gossamer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSAMLRoleReport(t *testing.T) {
+	initLog()
+	raw := strings.Replace(testSAMLResponse, "<saml:AttributeStatement>",
+		`<saml:Conditions NotBefore="2020-01-08T14:00:00Z" NotOnOrAfter="2020-01-08T14:05:00Z"></saml:Conditions><saml:AttributeStatement>`, 1)
+	encoded := base64.StdEncoding.EncodeToString([]byte(raw))
+	name := "test-flow"
+	sc := samlSessionConfig{assertion: &encoded, sessionName: &name}
+	if err := sc.decodeAssertion(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cases := []struct {
+		now   string
+		valid bool
+	}{
+		{now: "2020-01-08T13:59:59Z", valid: false},
+		{now: "2020-01-08T14:03:00Z", valid: true},
+		{now: "2020-01-08T14:05:00Z", valid: false},
+	}
+	for _, c := range cases {
+		now, _ := time.Parse(time.RFC3339, c.now)
+		r := sc.report(now)
+		if r.Valid != c.valid {
+			t.Errorf("unexpected validity at %s: want %v, got %v", c.now, c.valid, r.Valid)
+		}
+	}
+	r := sc.report(time.Now())
+	if len(r.Roles) != 1 || r.Roles[0].Identifier != "123456789012_readonly" {
+		t.Errorf("unexpected roles in report: %+v", r.Roles)
+	}
+	var buf bytes.Buffer
+	if err := r.WriteTable(&buf); err != nil {
+		t.Errorf("unexpected error writing table: %s", err)
+	}
+	if !strings.Contains(buf.String(), "arn:aws:iam::123456789012:saml-provider/idp") {
+		t.Errorf("expected principal ARN in table output:\n%s", buf.String())
+	}
+	buf.Reset()
+	if err := r.WriteJSON(&buf); err != nil {
+		t.Errorf("unexpected error writing json: %s", err)
+	}
+	if !strings.Contains(buf.String(), `"role_session_name": "cool-dude"`) {
+		t.Errorf("expected role session name in json output:\n%s", buf.String())
+	}
+}
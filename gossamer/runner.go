@@ -0,0 +1,111 @@
+package gossamer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/GESkunkworks/acfmgr"
+	"github.com/GESkunkworks/gossamer/goslogger"
+)
+
+// defaultMaxConcurrentFlows is how many flows run at once
+// when neither the config nor the caller say otherwise
+const defaultMaxConcurrentFlows = 4
+
+// flowResult holds the outcome of running a single flow
+type flowResult struct {
+	flow *Flow
+	pfis []*acfmgr.ProfileEntryInput
+	err  error
+}
+
+// run validates and executes the flow and collects its profile inputs
+func (f *Flow) run() (pfis []*acfmgr.ProfileEntryInput, err error) {
+	// call validate to make sure user didn't put crazy stuff in config
+	_, err = f.Validate()
+	if err != nil {
+		return pfis, err
+	}
+	// regardless of the flow type we'll always run primary
+	err = f.Execute()
+	if err != nil {
+		return pfis, err
+	}
+	goslogger.Loggo.Info("queueing assumptions to write to file", "flow", f.Name)
+	return f.GetAcfmgrProfileInputs()
+}
+
+// ExecuteFlows runs every flow in the config concurrently with at most
+// maxConcurrent running at once. A value less than one falls back to
+// max_concurrent_flows from the config or the package default. Prompts
+// are gathered for all flows up front so they don't interleave, and
+// flows using from_flow wait for the flow they depend on. The profile
+// inputs from every successful flow are returned so they can be written
+// in one go along with an error describing any flows that failed.
+func (gc *Config) ExecuteFlows(maxConcurrent int) (pfis []*acfmgr.ProfileEntryInput, err error) {
+	ordered, err := gc.OrderedFlows()
+	if err != nil {
+		return pfis, err
+	}
+	var flows []*Flow
+	for _, f := range ordered {
+		if !f.isSelected() {
+			goslogger.Loggo.Info("skipping flow not in selection", "flow", f.Name)
+			continue
+		}
+		flows = append(flows, f)
+	}
+	for _, f := range flows {
+		err = f.gatherPrompts()
+		if err != nil {
+			return pfis, err
+		}
+	}
+	if maxConcurrent < 1 {
+		maxConcurrent = gc.MaxConcurrentFlows
+	}
+	if maxConcurrent < 1 {
+		maxConcurrent = defaultMaxConcurrentFlows
+	}
+	goslogger.Loggo.Info("executing flows", "count", len(flows), "maxConcurrent", maxConcurrent)
+	done := make(map[string]chan struct{})
+	for _, f := range flows {
+		done[f.Name] = make(chan struct{})
+	}
+	sem := make(chan struct{}, maxConcurrent)
+	results := make([]flowResult, len(flows))
+	var wg sync.WaitGroup
+	for i, f := range flows {
+		wg.Add(1)
+		go func(i int, f *Flow) {
+			defer wg.Done()
+			defer close(done[f.Name])
+			// wait on dependencies before taking a slot so we can't deadlock
+			if f.FromFlowConfig != nil {
+				if ch, ok := done[f.FromFlowConfig.Flow]; ok {
+					<-ch
+				}
+			}
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			goslogger.Loggo.Info("starting flow", "flow", f.Name)
+			pfis, err := f.run()
+			results[i] = flowResult{flow: f, pfis: pfis, err: err}
+		}(i, f)
+	}
+	wg.Wait()
+	var failures []string
+	for _, r := range results {
+		if r.err != nil {
+			goslogger.Loggo.Error("flow failed", "flow", r.flow.Name, "error", r.err)
+			failures = append(failures, fmt.Sprintf("%s: %s", r.flow.Name, r.err))
+		}
+		goslogger.Loggo.Info("collected flow entries", "flow", r.flow.Name, "count", len(r.pfis))
+		pfis = append(pfis, r.pfis...)
+	}
+	if len(failures) > 0 {
+		err = fmt.Errorf("%d of %d flows failed: %s", len(failures), len(flows), strings.Join(failures, "; "))
+	}
+	return pfis, err
+}
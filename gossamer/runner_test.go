@@ -0,0 +1,48 @@
+package gossamer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExecuteFlowsErrors(t *testing.T) {
+	initLog()
+	cases := []struct {
+		flows       []*Flow
+		errContains []string
+	}{
+		{
+			// neither flow can validate so both failures are reported
+			flows: []*Flow{
+				{Name: "both-types", SAMLConfig: &SAMLConfig{}, PermCredsConfig: &PermCredsConfig{}},
+				{Name: "bad-region", PermCredsConfig: &PermCredsConfig{}, Region: "nowhere"},
+			},
+			errContains: []string{"2 of 2 flows failed", "both-types:", "bad-region:"},
+		},
+		{
+			flows: []*Flow{
+				{Name: "a", FromFlowConfig: &FromFlowConfig{Flow: "b"}},
+				{Name: "b", FromFlowConfig: &FromFlowConfig{Flow: "a"}},
+			},
+			errContains: []string{"flow dependency cycle detected"},
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		gc := Config{Flows: c.flows}
+		pfis, err := gc.ExecuteFlows(1)
+		if err == nil {
+			t.Errorf("expected error but got nil")
+			continue
+		}
+		for _, want := range c.errContains {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("expected error to contain '%s' but got '%s'", want, err)
+			}
+		}
+		if len(pfis) != 0 {
+			t.Errorf("expected no profile inputs but got %d", len(pfis))
+		}
+	}
+}
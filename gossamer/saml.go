@@ -2,13 +2,19 @@ package gossamer
 
 import (
 	"bytes"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"github.com/GESkunkworks/gossamer/goslogger"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
 	"golang.org/x/net/html"
 	"golang.org/x/net/publicsuffix"
 	"io"
@@ -16,8 +22,10 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // XMLSAMLResponse is the top level struct for holding and unmarshaling the XML
@@ -31,9 +39,17 @@ type XMLSAMLResponse struct {
 // assertion that comes back from the HTTP call.
 type XMLSAMLAssertion struct {
 	Issuer             string            `xml:"Issuer"`
+	Conditions         XMLSAMLConditions `xml:"Conditions"`
 	AttributeStatement XMLSAMLAttributes `xml:"AttributeStatement"`
 }
 
+// XMLSAMLConditions is required for holding and unmarshaling the validity
+// window of the XML SAML assertion that comes back from the HTTP call.
+type XMLSAMLConditions struct {
+	NotBefore    string `xml:"NotBefore,attr"`
+	NotOnOrAfter string `xml:"NotOnOrAfter,attr"`
+}
+
 // XMLSAMLAttributes is required for holding and unmarshaling the XML SAML
 // assertion that comes back from the HTTP call.
 type XMLSAMLAttributes struct {
@@ -61,37 +77,138 @@ func (sc *samlSessionConfig) decodeAssertion() (err error) {
 		return err
 	}
 	var r XMLSAMLResponse
-	err = xml.Unmarshal(parking, &r)
-	if err != nil {
-		goslogger.Loggo.Error("error unmarshaling SAML assertion to xml struct")
-		return err
+	if sc.idpCertificate != nil {
+		// only trust attributes that come out of the signed element
+		goslogger.Loggo.Debug("verifying SAML response signature against pinned IdP certificate")
+		r, err = verifySAMLResponse(parking, sc.idpCertificate)
+		if err != nil {
+			goslogger.Loggo.Error("error verifying SAML response signature", "error", err)
+			return err
+		}
+		goslogger.Loggo.Info("verified SAML response signature", "issuer", r.Assertion.Issuer)
+	} else {
+		err = xml.Unmarshal(parking, &r)
+		if err != nil {
+			goslogger.Loggo.Error("error unmarshaling SAML assertion to xml struct")
+			return err
+		}
 	}
 	var roles []*samlRole
+	sc.attributes = make(map[string][]string)
+	sc.principalTags = make(map[string]string)
 	for _, val := range r.Assertion.AttributeStatement.AttributeValues {
-		if val.Name == "https://aws.amazon.com/SAML/Attributes/Role" {
+		// keep every attribute around even if we don't know what it is
+		sc.attributes[val.Name] = append(sc.attributes[val.Name], val.AttributeValues...)
+		switch {
+		case val.Name == samlAttrRole:
 			for _, v := range val.AttributeValues {
 				role, err := newRoleFromAttributeValue(v)
 				if err != nil {
 					return err
 				}
+				if hasSAMLRole(roles, role) {
+					continue
+				}
 				roles = append(roles, role)
 			}
-		}
-		if val.Name == "https://aws.amazon.com/SAML/Attributes/RoleSessionName" {
+		case val.Name == samlAttrRoleSessionName:
 			if len(val.AttributeValues) > 0 {
 				sc.roleSessionName = &val.AttributeValues[0]
 			}
-		}
-		if val.Name == "https://aws.amazon.com/SAML/Attributes/SessionDuration" {
+		case val.Name == samlAttrSessionDuration:
 			if len(val.AttributeValues) > 0 {
 				sc.sessionDuration = &val.AttributeValues[0]
 			}
+		case val.Name == samlAttrSourceIdentity:
+			if len(val.AttributeValues) > 0 {
+				sc.sourceIdentity = &val.AttributeValues[0]
+			}
+		case val.Name == samlAttrTransitiveTagKeys:
+			sc.transitiveTagKeys = append(sc.transitiveTagKeys, val.AttributeValues...)
+		case strings.HasPrefix(val.Name, samlAttrPrincipalTagPrefix):
+			key := strings.TrimPrefix(val.Name, samlAttrPrincipalTagPrefix)
+			if len(key) > 0 && len(val.AttributeValues) > 0 {
+				sc.principalTags[key] = val.AttributeValues[0]
+			}
 		}
 	}
 	sc.roles = roles
+	sc.issuer = r.Assertion.Issuer
+	sc.notBefore = parseSAMLTime(r.Assertion.Conditions.NotBefore)
+	sc.notOnOrAfter = parseSAMLTime(r.Assertion.Conditions.NotOnOrAfter)
+	goslogger.Loggo.Info("decoded SAML assertion", sc.logContext()...)
 	return err
 }
 
+// parseSAMLTime parses the xs:dateTime values used in SAML
+// conditions and returns nil if the value is missing or invalid
+func parseSAMLTime(raw string) *time.Time {
+	if len(raw) < 1 {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		goslogger.Loggo.Debug("unable to parse SAML time", "value", raw, "error", err)
+		return nil
+	}
+	return &t
+}
+
+// logContext returns the interesting bits of the decoded assertion as
+// key value pairs suitable for passing to the logger
+func (sc *samlSessionConfig) logContext() (ctx []interface{}) {
+	ctx = append(ctx, "roles", len(sc.roles))
+	if sc.roleSessionName != nil {
+		ctx = append(ctx, "RoleSessionName", *sc.roleSessionName)
+	}
+	if sc.sessionDuration != nil {
+		ctx = append(ctx, "SessionDuration", *sc.sessionDuration)
+	}
+	if sc.sourceIdentity != nil {
+		ctx = append(ctx, "SourceIdentity", *sc.sourceIdentity)
+	}
+	if len(sc.principalTags) > 0 {
+		var tags []string
+		for k, v := range sc.principalTags {
+			tags = append(tags, fmt.Sprintf("%s=%s", k, v))
+		}
+		sort.Strings(tags)
+		ctx = append(ctx, "PrincipalTags", strings.Join(tags, ","))
+	}
+	if len(sc.transitiveTagKeys) > 0 {
+		ctx = append(ctx, "TransitiveTagKeys", strings.Join(sc.transitiveTagKeys, ","))
+	}
+	ctx = append(ctx, "attributes", len(sc.attributes))
+	return ctx
+}
+
+// getSessionAttributes converts the session tags and source identity from
+// the assertion into what secondary assumptions need in order to carry them
+// down the role chain when the flow sets propagate_saml_attributes. Tags the IdP already marked as transitive are left
+// out since STS carries those automatically and rejects them if passed again.
+func (sc *samlSessionConfig) getSessionAttributes() (sa *sessionAttributes) {
+	sa = &sessionAttributes{sourceIdentity: sc.sourceIdentity}
+	transitive := make(map[string]bool)
+	for _, k := range sc.transitiveTagKeys {
+		transitive[strings.ToLower(k)] = true
+	}
+	var keys []string
+	for k := range sc.principalTags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if transitive[strings.ToLower(k)] {
+			continue
+		}
+		sa.tags = append(sa.tags, &sts.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(sc.principalTags[k]),
+		})
+	}
+	return sa
+}
+
 // samlSessionConfig holds information required to begin
 // the SAML session including things like the username/password
 // and the URL
@@ -107,6 +224,14 @@ type samlSessionConfig struct {
 	sessionDuration              *string
 	stsClient                    *sts.STS
 	allowMappingDurationOverride bool
+	idpCertificate               *x509.Certificate
+	attributes                   map[string][]string
+	principalTags                map[string]string
+	transitiveTagKeys            []string
+	sourceIdentity               *string
+	issuer                       string
+	notBefore                    *time.Time
+	notOnOrAfter                 *time.Time
 }
 
 func (sc *samlSessionConfig) getSessionDuration() (duration int64) {
@@ -129,6 +254,17 @@ type samlRole struct {
 	identifier    string
 }
 
+// hasSAMLRole returns true if the same role and principal pair
+// is already present in roles
+func hasSAMLRole(roles []*samlRole, role *samlRole) bool {
+	for _, r := range roles {
+		if r.roleArn == role.roleArn && r.principalArn == role.principalArn {
+			return true
+		}
+	}
+	return false
+}
+
 // newSAMLSessionConfig returns a samlSessionConfig struct whose methods can be
 // called to start a SAML session via HTTP and also assume roles that come back
 // from the session's assertion
@@ -166,6 +302,109 @@ func (sc *samlSessionConfig) startSAMLSession() (err error) {
 	return err
 }
 
+const (
+	samlProtocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlAssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+	samlAttrRole               = "https://aws.amazon.com/SAML/Attributes/Role"
+	samlAttrRoleSessionName    = "https://aws.amazon.com/SAML/Attributes/RoleSessionName"
+	samlAttrSessionDuration    = "https://aws.amazon.com/SAML/Attributes/SessionDuration"
+	samlAttrSourceIdentity     = "https://aws.amazon.com/SAML/Attributes/SourceIdentity"
+	samlAttrTransitiveTagKeys  = "https://aws.amazon.com/SAML/Attributes/TransitiveTagKeys"
+	samlAttrPrincipalTagPrefix = "https://aws.amazon.com/SAML/Attributes/PrincipalTag:"
+)
+
+// parseIdPCertificate takes either a PEM encoded certificate or the bare
+// base64 DER encoding that's usually found in IdP metadata and returns
+// the parsed certificate.
+func parseIdPCertificate(raw string) (cert *x509.Certificate, err error) {
+	block, _ := pem.Decode([]byte(raw))
+	if block != nil {
+		return x509.ParseCertificate(block.Bytes)
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(raw), ""))
+	if err != nil {
+		err = errors.New("idp certificate is neither PEM nor base64 encoded DER")
+		return cert, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// verifySAMLResponse checks the enveloped XML signature on either the
+// Response or the Assertion against the pinned certificate and returns
+// the response built only from the element that was actually signed so
+// that nothing outside the signature can be smuggled in.
+func verifySAMLResponse(raw []byte, cert *x509.Certificate) (r XMLSAMLResponse, err error) {
+	doc := etree.NewDocument()
+	err = doc.ReadFromBytes(raw)
+	if err != nil {
+		return r, err
+	}
+	root := doc.Root()
+	if root == nil || root.Tag != "Response" || root.NamespaceURI() != samlProtocolNamespace {
+		err = errors.New("SAML response root element is not a samlp:Response")
+		return r, err
+	}
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+	ctx.IdAttribute = "ID"
+	sig, err := etreeutils.NSFindOneChild(root, dsig.Namespace, dsig.SignatureTag)
+	if err != nil {
+		return r, err
+	}
+	if sig != nil {
+		goslogger.Loggo.Debug("found signature on SAML Response")
+		var verified *etree.Element
+		verified, err = ctx.Validate(root)
+		if err != nil {
+			return r, fmt.Errorf("invalid signature on SAML Response: %s", err)
+		}
+		err = unmarshalElement(verified, &r)
+		return r, err
+	}
+	// the Response itself isn't signed so the Assertion has to be
+	nsCtx, err := etreeutils.NSBuildParentContext(root)
+	if err != nil {
+		return r, err
+	}
+	nsCtx, err = nsCtx.SubContext(root)
+	if err != nil {
+		return r, err
+	}
+	assertion, err := etreeutils.NSFindOneChildCtx(nsCtx, root, samlAssertionNamespace, "Assertion")
+	if err != nil {
+		return r, err
+	}
+	if assertion == nil {
+		err = errors.New("SAML response is not signed and contains no Assertion")
+		return r, err
+	}
+	detached, err := etreeutils.NSDetatch(nsCtx, assertion)
+	if err != nil {
+		return r, err
+	}
+	goslogger.Loggo.Debug("no signature on SAML Response so checking Assertion")
+	verified, err := ctx.Validate(detached)
+	if err != nil {
+		return r, fmt.Errorf("invalid signature on SAML Assertion: %s", err)
+	}
+	err = unmarshalElement(verified, &r.Assertion)
+	return r, err
+}
+
+// unmarshalElement serializes an etree element back out so that it
+// can be unmarshaled into the usual encoding/xml structs
+func unmarshalElement(el *etree.Element, v interface{}) (err error) {
+	doc := etree.NewDocument()
+	doc.SetRoot(el)
+	b, err := doc.WriteToBytes()
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(b, v)
+}
+
 func getRoleUniqueID(roleArn string) (uid *string, err error) {
 	rolename, accountnumber, err := parseRoleArn(roleArn)
 	if err != nil {
@@ -193,6 +432,14 @@ func parseRoleArn(roleArn string) (rolename, accountnumber *string, err error) {
 	return rolename, accountnumber, err
 }
 
+var (
+	samlProviderArnRegex = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:saml-provider/.+$`)
+	roleArnRegex         = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:role/.+$`)
+)
+
+// newRoleFromAttributeValue parses a Role attribute value from the SAML
+// assertion. AWS accepts the role and principal ARNs in either order so
+// we work out which is which from the ARN itself.
 func newRoleFromAttributeValue(raw string) (*samlRole, error) {
 	role := samlRole{}
 	var err error
@@ -201,9 +448,20 @@ func newRoleFromAttributeValue(raw string) (*samlRole, error) {
 		err = errors.New("error parsing PrincipalArn from saml:AttributeValue during comma split")
 		return &role, err
 	}
-	role.principalArn = parn[1]
-
-	role.roleArn = parn[0]
+	for _, part := range parn {
+		part = strings.TrimSpace(part)
+		switch {
+		case samlProviderArnRegex.MatchString(part):
+			role.principalArn = part
+		case roleArnRegex.MatchString(part):
+			role.roleArn = part
+		}
+	}
+	if len(role.principalArn) < 1 || len(role.roleArn) < 1 {
+		msg := fmt.Sprintf("unable to find both a role and saml-provider ARN in saml:AttributeValue '%s'", raw)
+		err = errors.New(msg)
+		return &role, err
+	}
 	rolename, accountnumber, err := parseRoleArn(role.roleArn)
 	if err != nil {
 		return &role, err
@@ -283,7 +541,7 @@ func (sc *samlSessionConfig) getAssertion() (err error) {
 }
 
 // assumeSAMLRoles uses the previously obtained assertion to attempt to either assume
-// all possible roles in the assertion as indicated by the allRoles input boolean
+// the roles in the assertion picked by all_roles or the include/exclude rules
 // or simply assume a preset list of mappings passed in with preAssumptions
 // it returns a slice of gossamer.Mapping structs which can hold more metadata than the
 // SAMLRoles that have been built thus far
@@ -292,10 +550,17 @@ func (sc *samlSessionConfig) assumeSAMLRoles(preAssumptions *Assumptions) (err e
 	// add mappings from saml assertion we don't know about already
 	for _, role := range sc.roles {
 		var found bool
-		found, _ = preAssumptions.getMapping(role.roleArn)
-		if !preAssumptions.AllRoles && !found {
+		found, _ = preAssumptions.getMapping(role.roleArn, role.principalArn)
+		selected, rule := preAssumptions.selectRole(role.roleArn)
+		if !selected && !found {
 			goslogger.Loggo.Debug("Skipping role assumption per configuration directives", "role.roleArn", role.roleArn)
 			continue
+		} else if !found && preAssumptions.roleMappedViaOtherPrincipal(role.roleArn, role.principalArn) {
+			goslogger.Loggo.Info("Skipping role already mapped through another identity provider, set principal_arn on a mapping to assume it via this one",
+				"roleArn", role.roleArn,
+				"principalArn", role.principalArn,
+			)
+			continue
 		} else if !found {
 			var m *Mapping
 			goslogger.Loggo.Debug("no user defined mapping found, creating new saml mapping", "roleArn", role.roleArn)
@@ -304,6 +569,14 @@ func (sc *samlSessionConfig) assumeSAMLRoles(preAssumptions *Assumptions) (err e
 				role.principalArn,
 				sc,
 			)
+			if rule != nil {
+				// so the rule's template can see the config's aliases and normalization
+				m.setRelationships(preAssumptions, preAssumptions.parentFlow, preAssumptions.parentConfig)
+				err = rule.apply(m, *sc.sessionName)
+				if err != nil {
+					return err
+				}
+			}
 			preAssumptions.Mappings = append(preAssumptions.Mappings, *m)
 		} else {
 			// set the saml stuff on the pre-known mapping
@@ -315,6 +588,8 @@ func (sc *samlSessionConfig) assumeSAMLRoles(preAssumptions *Assumptions) (err e
 	if err != nil {
 		return err
 	}
+	// skip anything that isn't selected or needed by a selected mapping
+	preAssumptions.parentFlow.markSelection()
 	// now go through all the mappings and do the assumptions
 	preAssumptions.assumeMappingsConcurrent()
 	return err
@@ -0,0 +1,273 @@
+package gossamer
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const testSAMLResponse = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_resp1" Version="2.0">` +
+	`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_assert1" Version="2.0">` +
+	`<saml:Issuer>https://idp.example.com</saml:Issuer>` +
+	`<saml:AttributeStatement>` +
+	`<saml:Attribute Name="https://aws.amazon.com/SAML/Attributes/Role">` +
+	`<saml:AttributeValue>arn:aws:iam::123456789012:role/readonly,arn:aws:iam::123456789012:saml-provider/idp</saml:AttributeValue>` +
+	`</saml:Attribute>` +
+	`<saml:Attribute Name="https://aws.amazon.com/SAML/Attributes/RoleSessionName">` +
+	`<saml:AttributeValue>cool-dude</saml:AttributeValue>` +
+	`</saml:Attribute>` +
+	`</saml:AttributeStatement>` +
+	`</saml:Assertion>` +
+	`</samlp:Response>`
+
+// signTestSAMLResponse signs either the Response or the Assertion of the
+// test response with the provided keystore and returns the serialized doc
+func signTestSAMLResponse(t *testing.T, ks dsig.X509KeyStore, signAssertion bool) string {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(testSAMLResponse); err != nil {
+		t.Fatal(err)
+	}
+	ctx := dsig.NewDefaultSigningContext(ks)
+	ctx.IdAttribute = "ID"
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	root := doc.Root()
+	if signAssertion {
+		assertion := root.FindElement("./Assertion")
+		signed, err := ctx.SignEnveloped(assertion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root.RemoveChild(assertion)
+		root.AddChild(signed)
+	} else {
+		signed, err := ctx.SignEnveloped(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc.SetRoot(signed)
+	}
+	s, err := doc.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func getTestCert(t *testing.T, ks dsig.X509KeyStore) *x509.Certificate {
+	_, der, err := ks.GetKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestDecodeAssertionSignature(t *testing.T) {
+	initLog()
+	ks := dsig.RandomKeyStoreForTest()
+	otherKs := dsig.RandomKeyStoreForTest()
+	cert := getTestCert(t, ks)
+	signedResponse := signTestSAMLResponse(t, ks, false)
+	signedAssertion := signTestSAMLResponse(t, ks, true)
+	cases := []struct {
+		name      string
+		xml       string
+		cert      *x509.Certificate
+		wantErr   bool
+		wantRoles int
+	}{
+		{name: "no pinned cert skips verification", xml: testSAMLResponse, wantRoles: 1},
+		{name: "unsigned response", xml: testSAMLResponse, cert: cert, wantErr: true},
+		{name: "signed response", xml: signedResponse, cert: cert, wantRoles: 1},
+		{name: "signed assertion", xml: signedAssertion, cert: cert, wantRoles: 1},
+		{name: "wrong cert", xml: signedResponse, cert: getTestCert(t, otherKs), wantErr: true},
+		{
+			name:    "tampered response",
+			xml:     strings.Replace(signedResponse, "role/readonly", "role/admin", 1),
+			cert:    cert,
+			wantErr: true,
+		},
+		{
+			name:    "tampered assertion",
+			xml:     strings.Replace(signedAssertion, "role/readonly", "role/admin", 1),
+			cert:    cert,
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		fmt.Println("test case: ", c.name)
+		encoded := base64.StdEncoding.EncodeToString([]byte(c.xml))
+		sc := samlSessionConfig{assertion: &encoded, idpCertificate: c.cert}
+		err := sc.decodeAssertion()
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error but got nil", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+			continue
+		}
+		if len(sc.roles) != c.wantRoles {
+			t.Errorf("%s: unexpected roles: want %d, got %d", c.name, c.wantRoles, len(sc.roles))
+		}
+		if sc.roleSessionName == nil || *sc.roleSessionName != "cool-dude" {
+			t.Errorf("%s: expected roleSessionName to be parsed", c.name)
+		}
+	}
+}
+
+func TestParseIdPCertificate(t *testing.T) {
+	initLog()
+	ks := dsig.RandomKeyStoreForTest()
+	_, der, _ := ks.GetKeyPair()
+	b64 := base64.StdEncoding.EncodeToString(der)
+	pemCert := "-----BEGIN CERTIFICATE-----\n" + b64 + "\n-----END CERTIFICATE-----\n"
+	cases := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{raw: pemCert},
+		{raw: b64},
+		{raw: b64[:40] + "\n  " + b64[40:]},
+		{raw: "not a cert", wantErr: true},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		_, err := parseIdPCertificate(c.raw)
+		if (err != nil) != c.wantErr {
+			t.Errorf("unexpected error result: wantErr %v, got '%v'", c.wantErr, err)
+		}
+	}
+}
+
+func TestDecodeAssertionSessionAttributes(t *testing.T) {
+	initLog()
+	attr := func(name string, values ...string) string {
+		x := `<saml:Attribute Name="` + name + `">`
+		for _, v := range values {
+			x += `<saml:AttributeValue>` + v + `</saml:AttributeValue>`
+		}
+		return x + `</saml:Attribute>`
+	}
+	extra := attr(samlAttrPrincipalTagPrefix+"Project", "unicorn") +
+		attr(samlAttrPrincipalTagPrefix+"CostCenter", "12345") +
+		attr(samlAttrTransitiveTagKeys, "Project") +
+		attr(samlAttrSourceIdentity, "dudette@fake.com") +
+		attr("urn:oid:0.9.2342.19200300.100.1.3", "dudette@fake.com")
+	raw := strings.Replace(testSAMLResponse, "</saml:AttributeStatement>", extra+"</saml:AttributeStatement>", 1)
+	encoded := base64.StdEncoding.EncodeToString([]byte(raw))
+	sc := samlSessionConfig{assertion: &encoded}
+	if err := sc.decodeAssertion(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(sc.attributes) != 7 {
+		t.Errorf("unexpected attribute count: want 7, got %d", len(sc.attributes))
+	}
+	if sc.principalTags["Project"] != "unicorn" || sc.principalTags["CostCenter"] != "12345" {
+		t.Errorf("unexpected principal tags: %v", sc.principalTags)
+	}
+	if sc.sourceIdentity == nil || *sc.sourceIdentity != "dudette@fake.com" {
+		t.Error("expected SourceIdentity to be parsed")
+	}
+	sa := sc.getSessionAttributes()
+	// transitive tags carry through the chain on their own so only CostCenter is passed
+	if len(sa.tags) != 1 || *sa.tags[0].Key != "CostCenter" {
+		t.Errorf("unexpected session tags: %v", sa.tags)
+	}
+	if sa.sourceIdentity == nil || *sa.sourceIdentity != "dudette@fake.com" {
+		t.Error("expected SourceIdentity to be propagated")
+	}
+}
+
+func TestNewRoleFromAttributeValue(t *testing.T) {
+	initLog()
+	roleArn := "arn:aws:iam::123456789012:role/oo/cool-role"
+	principalArn := "arn:aws:iam::123456789012:saml-provider/azure-ad"
+	cases := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{raw: roleArn + "," + principalArn},
+		{raw: principalArn + "," + roleArn},
+		{raw: " " + principalArn + " ,\n\t" + roleArn + " "},
+		{raw: roleArn + "," + roleArn, wantErr: true},
+		{raw: principalArn + "," + principalArn, wantErr: true},
+		{raw: roleArn, wantErr: true},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		role, err := newRoleFromAttributeValue(c.raw)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("expected error for '%s' but got nil", c.raw)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if role.roleArn != roleArn || role.principalArn != principalArn {
+			t.Errorf("unexpected parse: got role '%s' principal '%s'", role.roleArn, role.principalArn)
+		}
+		if role.roleName != "oo/cool-role" || role.accountNumber != "123456789012" {
+			t.Errorf("unexpected role name/account: '%s' '%s'", role.roleName, role.accountNumber)
+		}
+	}
+}
+
+func TestGetMappingByPrincipal(t *testing.T) {
+	initLog()
+	roleArn := "arn:aws:iam::123456789012:role/admin"
+	idpA := "arn:aws:iam::123456789012:saml-provider/idp-a"
+	idpB := "arn:aws:iam::123456789012:saml-provider/idp-b"
+	expected := map[string]string{idpA: "admin-any", idpB: "admin-b"}
+	// the assertion can list the principals in any order
+	for i, order := range [][]string{{idpA, idpB}, {idpB, idpA}} {
+		fmt.Println("test case: ", i)
+		a := Assumptions{Mappings: []Mapping{
+			{RoleArn: roleArn, ProfileName: "admin-b", PrincipalArn: idpB},
+			{RoleArn: roleArn, ProfileName: "admin-any"},
+		}}
+		for _, principal := range order {
+			if ok, m := a.getMapping(roleArn, principal); !ok || m.ProfileName != expected[principal] {
+				t.Errorf("expected '%s' to be found for '%s' but got %t %+v", expected[principal], principal, ok, m)
+			}
+			a.setMappingSAMLStuff(roleArn, principal, nil)
+		}
+		if a.Mappings[0].samlPrincipalArn != idpB {
+			t.Errorf("pinned mapping was bound to '%s'", a.Mappings[0].samlPrincipalArn)
+		}
+		if a.Mappings[1].samlPrincipalArn != idpA {
+			t.Errorf("unpinned mapping was bound to '%s'", a.Mappings[1].samlPrincipalArn)
+		}
+	}
+}
+
+func TestGatherPromptsRejectsIdPCertificatePrompt(t *testing.T) {
+	initLog()
+	f := &Flow{
+		Name: "saml",
+		SAMLConfig: &SAMLConfig{
+			Username:       &CParam{Source: "prompt"},
+			IdPCertificate: &CParam{Source: "prompt"},
+		},
+	}
+	err := f.gatherPrompts()
+	if err == nil || !strings.Contains(err.Error(), "idp_certificate") {
+		t.Errorf("expected idp_certificate error but got '%v'", err)
+	}
+	if f.SAMLConfig.Username.gathered {
+		t.Error("expected no prompts before the idp_certificate was rejected")
+	}
+}
@@ -0,0 +1,211 @@
+package gossamer
+
+import (
+	"strings"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+)
+
+// Selection narrows a run down to particular flows, profiles and tags.
+// Empty lists match everything so the zero value selects the whole config.
+type Selection struct {
+	Flows    []string
+	Profiles []string
+	Tags     []string
+}
+
+// NewSelection builds a Selection from comma separated lists
+// such as the ones passed on the command line
+func NewSelection(flows, profiles, tags string) Selection {
+	return Selection{
+		Flows:    splitList(flows),
+		Profiles: splitList(profiles),
+		Tags:     splitList(tags),
+	}
+}
+
+// splitList splits a comma separated list dropping any blank items
+func splitList(s string) (items []string) {
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Selection) isEmpty() bool {
+	return s == nil || (len(s.Flows) == 0 && len(s.Profiles) == 0 && len(s.Tags) == 0)
+}
+
+func (s *Selection) matchesFlowName(name string) bool {
+	return s.isEmpty() || len(s.Flows) == 0 || containsString(s.Flows, name)
+}
+
+// matchesMapping returns true if the mapping matches the profile and
+// tag selectors. Tags set on the flow apply to all of its mappings.
+func (s *Selection) matchesMapping(m *Mapping, f *Flow) bool {
+	if s.isEmpty() {
+		return true
+	}
+	if len(s.Profiles) > 0 {
+		found := false
+		for _, p := range s.Profiles {
+			if m.matchesRef(p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(s.Tags) > 0 {
+		for _, t := range s.Tags {
+			if containsString(f.Tags, t) || containsString(m.Tags, t) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Select limits the flows and mappings that will be run to those matching
+// sel. Flows that a selected flow starts from (via from_flow) are kept so
+// their source mapping can be assumed but nothing else from them is written.
+func (gc *Config) Select(sel Selection) (err error) {
+	for _, name := range sel.Flows {
+		_, err = gc.GetFlow(name, "")
+		if err != nil {
+			return err
+		}
+	}
+	gc.selection = &sel
+	flows, err := gc.OrderedFlows()
+	if err != nil {
+		return err
+	}
+	for _, f := range flows {
+		f.requiredRefs = nil
+	}
+	// walk backwards so dependents are seen before the flows they start from
+	for i := len(flows) - 1; i >= 0; i-- {
+		f := flows[i]
+		if !f.isSelected() || f.FromFlowConfig == nil {
+			continue
+		}
+		source, err := gc.GetFlow(f.FromFlowConfig.Flow, "")
+		if err != nil {
+			return err
+		}
+		source.requiredRefs = append(source.requiredRefs, f.FromFlowConfig.Mapping)
+	}
+	goslogger.Loggo.Info("applied selection",
+		"flows", strings.Join(sel.Flows, ","),
+		"profiles", strings.Join(sel.Profiles, ","),
+		"tags", strings.Join(sel.Tags, ","),
+	)
+	return err
+}
+
+// getSelection returns the selection from the flow's config if any
+func (f *Flow) getSelection() *Selection {
+	if f == nil || f.parentConfig == nil {
+		return nil
+	}
+	return f.parentConfig.selection
+}
+
+// isSelected returns true if the flow needs to run. Flows with roles
+// coming from a SAML assertion can't be ruled out until they log in.
+func (f *Flow) isSelected() bool {
+	sel := f.getSelection()
+	if sel.isEmpty() || len(f.requiredRefs) > 0 {
+		return true
+	}
+	if !sel.matchesFlowName(f.Name) {
+		return false
+	}
+	if f.hasDynamicPrimaries() {
+		return true
+	}
+	for _, a := range []*Assumptions{f.PAss, f.SAss} {
+		if a == nil {
+			continue
+		}
+		for i := range a.Mappings {
+			if sel.matchesMapping(&a.Mappings[i], f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// markSelection flags the flow's mappings that aren't selected so they
+// aren't written and skips assuming any that aren't needed as a sponsor
+// by a selected mapping. It must be called once all primaries are known.
+func (f *Flow) markSelection() {
+	sel := f.getSelection()
+	if sel.isEmpty() {
+		return
+	}
+	// link sponsors so we can tell what each selected mapping needs,
+	// problems are reported when the secondaries are assumed
+	_ = f.resolveSponsors(false)
+	flowSelected := sel.matchesFlowName(f.Name)
+	wanted := make(map[*Mapping]bool)
+	var want func(m *Mapping)
+	want = func(m *Mapping) {
+		if m == nil || wanted[m] {
+			return
+		}
+		wanted[m] = true
+		want(m.sponsor)
+	}
+	for _, a := range []*Assumptions{f.PAss, f.SAss} {
+		if a == nil {
+			continue
+		}
+		for i := range a.Mappings {
+			m := &a.Mappings[i]
+			m.unselected = !flowSelected || !sel.matchesMapping(m, f)
+			if !m.unselected {
+				want(m)
+			}
+		}
+	}
+	for _, ref := range f.requiredRefs {
+		want(f.findMappingByRef(ref))
+	}
+	// accounts discovered later on need the discovery sponsor
+	if flowSelected && f.SAss != nil && f.SAss.Organizations != nil {
+		want(f.organizationsSponsor())
+	}
+	for _, a := range []*Assumptions{f.PAss, f.SAss} {
+		if a == nil {
+			continue
+		}
+		for i := range a.Mappings {
+			m := &a.Mappings[i]
+			m.skip = !wanted[m]
+			switch {
+			case m.skip:
+				goslogger.Loggo.Debug("skipping mapping not in selection", "flow", f.Name, "roleArn", m.RoleArn)
+			case m.unselected:
+				goslogger.Loggo.Info("assuming unselected mapping because a selected mapping needs it", "flow", f.Name, "roleArn", m.RoleArn)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package gossamer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func TestMarkSelection(t *testing.T) {
+	initLog()
+	primaries := []Mapping{
+		{RoleArn: "arn:aws:iam::111111111111:role/hub", ProfileName: "hub"},
+		{RoleArn: "arn:aws:iam::111111111111:role/other", ProfileName: "other", Tags: []string{"ops"}},
+	}
+	secondaries := []Mapping{
+		{RoleArn: "arn:aws:iam::222222222222:role/spoke", ProfileName: "spoke", Sponsor: "hub"},
+		{RoleArn: "arn:aws:iam::333333333333:role/workload", ProfileName: "workload", Sponsor: "spoke", Tags: []string{"team"}},
+	}
+	cases := []struct {
+		sel        Selection
+		flowTags   []string
+		selected   []string
+		assumed    []string
+		isSelected bool
+	}{
+		{
+			// a secondary pulls in its whole sponsor chain but only it is written
+			sel:        Selection{Profiles: []string{"workload"}},
+			selected:   []string{"workload"},
+			assumed:    []string{"hub", "spoke", "workload"},
+			isSelected: true,
+		},
+		{
+			sel:        Selection{Tags: []string{"ops"}},
+			selected:   []string{"other"},
+			assumed:    []string{"other"},
+			isSelected: true,
+		},
+		{
+			// flow tags apply to every mapping
+			sel:        Selection{Tags: []string{"ops"}},
+			flowTags:   []string{"ops"},
+			selected:   []string{"hub", "other", "spoke", "workload"},
+			assumed:    []string{"hub", "other", "spoke", "workload"},
+			isSelected: true,
+		},
+		{
+			sel:        Selection{Flows: []string{"chain"}, Profiles: []string{"arn:aws:iam::111111111111:role/other"}},
+			selected:   []string{"other"},
+			assumed:    []string{"other"},
+			isSelected: true,
+		},
+		{
+			sel:        Selection{Flows: []string{"elsewhere"}},
+			isSelected: false,
+		},
+		{
+			sel:        Selection{Tags: []string{"nope"}},
+			isSelected: false,
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := newTestChainFlow(
+			append([]Mapping{}, primaries...),
+			append([]Mapping{}, secondaries...),
+		)
+		f.Tags = c.flowTags
+		f.parentConfig.selection = &c.sel
+		if got := f.isSelected(); got != c.isSelected {
+			t.Errorf("expected isSelected %t but got %t", c.isSelected, got)
+		}
+		f.markSelection()
+		for _, a := range []*Assumptions{f.PAss, f.SAss} {
+			for _, m := range a.Mappings {
+				if m.unselected == containsString(c.selected, m.ProfileName) {
+					t.Errorf("unexpected unselected=%t for '%s'", m.unselected, m.ProfileName)
+				}
+				if m.skip == containsString(c.assumed, m.ProfileName) {
+					t.Errorf("unexpected skip=%t for '%s'", m.skip, m.ProfileName)
+				}
+			}
+		}
+	}
+}
+
+func TestSelectKeepsFromFlowSource(t *testing.T) {
+	initLog()
+	source := &Flow{
+		Name:            "source",
+		PermCredsConfig: &PermCredsConfig{},
+		PAss: &Assumptions{Mappings: []Mapping{
+			{RoleArn: "arn:aws:iam::111111111111:role/landing", ProfileName: "landing"},
+			{RoleArn: "arn:aws:iam::111111111111:role/extra", ProfileName: "extra"},
+		}},
+	}
+	dependent := &Flow{
+		Name:           "dependent",
+		FromFlowConfig: &FromFlowConfig{Flow: "source", Mapping: "landing"},
+		PAss: &Assumptions{Mappings: []Mapping{
+			{RoleArn: "arn:aws:iam::222222222222:role/team", ProfileName: "team"},
+		}},
+	}
+	gc := Config{Flows: []*Flow{source, dependent}}
+	for _, f := range gc.Flows {
+		f.setRelationships(&gc)
+	}
+	err := gc.Select(Selection{Flows: []string{"dependent"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !source.isSelected() {
+		t.Error("expected source flow to be kept for its dependent")
+	}
+	source.markSelection()
+	landing, extra := source.PAss.Mappings[0], source.PAss.Mappings[1]
+	if landing.skip || !landing.unselected {
+		t.Errorf("expected landing to be assumed but not written, got skip=%t unselected=%t", landing.skip, landing.unselected)
+	}
+	if !extra.skip {
+		t.Error("expected extra to be skipped")
+	}
+	// nothing selected to write from the source flow so that's not a failure
+	source.PAss.Mappings[0].credential = &sts.Credentials{}
+	pfis, err := source.PAss.getAcfmgrProfileInputs()
+	if err != nil || len(pfis) != 0 {
+		t.Errorf("expected no inputs and no error but got %d and '%v'", len(pfis), err)
+	}
+	err = gc.Select(Selection{Flows: []string{"missing"}})
+	if err == nil {
+		t.Error("expected error selecting missing flow")
+	}
+}
@@ -0,0 +1,227 @@
+package gossamer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+)
+
+// RoleSelector describes a rule for picking roles out of a SAML
+// assertion. Every pattern that is set must match for the rule to
+// match. Patterns are globs ('*' and '?') unless Regex is true.
+// When used as an include rule the ProfileNameTemplate and Region
+// are applied to the mappings generated from the matching roles.
+type RoleSelector struct {
+	Account             string `yaml:"account,omitempty"`
+	RoleName            string `yaml:"role_name,omitempty"`
+	RolePath            string `yaml:"role_path,omitempty"`
+	Arn                 string `yaml:"arn,omitempty"`
+	Regex               bool   `yaml:"regex,omitempty"`
+	ProfileNameTemplate string `yaml:"profile_name_template,omitempty"`
+	Region              string `yaml:"region,omitempty"`
+	account             *regexp.Regexp
+	roleName            *regexp.Regexp
+	rolePath            *regexp.Regexp
+	arn                 *regexp.Regexp
+	profileNameTemplate *template.Template
+}
+
+// profileNameData holds the values that can be referenced
+// from a profile name template
+type profileNameData struct {
+	AccountNumber string
+	AccountAlias  string // alias of the account or the account number if it has none
+	AccountName   string // name of the account in Organizations or AccountAlias if unknown
+	RoleName      string
+	RolePath      string
+	RoleArn       string
+	Region        string
+	FlowName      string
+	ProfileName   string // only set for region_profile_name_template
+}
+
+// newProfileNameData fills in the template values for a role
+// looking up the account alias from the config (if any)
+func newProfileNameData(roleArn, region, flowName string, gc *Config) (data profileNameData, err error) {
+	rolename, accountnumber, err := parseRoleArn(roleArn)
+	if err != nil {
+		return data, err
+	}
+	path, name := splitRolePath(*rolename)
+	data = profileNameData{
+		AccountNumber: *accountnumber,
+		AccountAlias:  *accountnumber,
+		RoleName:      name,
+		RolePath:      path,
+		RoleArn:       roleArn,
+		Region:        region,
+		FlowName:      flowName,
+	}
+	if alias, ok := gc.getAccountAlias(*accountnumber); ok {
+		data.AccountAlias = alias
+	}
+	data.AccountName = data.AccountAlias
+	if name, ok := gc.getAccountName(*accountnumber); ok {
+		data.AccountName = name
+	}
+	return data, err
+}
+
+// compilePattern turns a glob or regex from the config into
+// an anchored regular expression
+func compilePattern(pattern string, isRegex bool) (re *regexp.Regexp, err error) {
+	if len(pattern) < 1 {
+		return re, err
+	}
+	if isRegex {
+		return regexp.Compile("^(?:" + pattern + ")$")
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// compile prepares the selector's patterns and template
+// so they can be used for matching
+func (rs *RoleSelector) compile() (err error) {
+	if len(rs.Account) < 1 && len(rs.RoleName) < 1 && len(rs.RolePath) < 1 && len(rs.Arn) < 1 {
+		err = errors.New("role selector must set at least one of account, role_name, role_path, or arn")
+		return err
+	}
+	patterns := []struct {
+		label   string
+		pattern string
+		dest    **regexp.Regexp
+	}{
+		{"account", rs.Account, &rs.account},
+		{"role_name", rs.RoleName, &rs.roleName},
+		{"role_path", rs.RolePath, &rs.rolePath},
+		{"arn", rs.Arn, &rs.arn},
+	}
+	for _, p := range patterns {
+		*p.dest, err = compilePattern(p.pattern, rs.Regex)
+		if err != nil {
+			msg := fmt.Sprintf("error compiling role selector %s '%s': %s", p.label, p.pattern, err)
+			err = errors.New(msg)
+			return err
+		}
+	}
+	if len(rs.ProfileNameTemplate) > 0 {
+		rs.profileNameTemplate, err = template.New("profile_name").Option("missingkey=error").Parse(rs.ProfileNameTemplate)
+		if err != nil {
+			msg := fmt.Sprintf("error parsing role selector profile_name_template: %s", err)
+			err = errors.New(msg)
+			return err
+		}
+	}
+	return err
+}
+
+// matches returns true if every pattern set on the selector
+// matches the given role
+func (rs *RoleSelector) matches(roleArn string) bool {
+	rolename, accountnumber, err := parseRoleArn(roleArn)
+	if err != nil {
+		return false
+	}
+	path, name := splitRolePath(*rolename)
+	checks := []struct {
+		re    *regexp.Regexp
+		value string
+	}{
+		{rs.account, *accountnumber},
+		{rs.roleName, name},
+		{rs.rolePath, path},
+		{rs.arn, roleArn},
+	}
+	for _, c := range checks {
+		if c.re != nil && !c.re.MatchString(c.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply sets the selector's region and templated profile name
+// on a mapping that was generated from a matching role
+func (rs *RoleSelector) apply(m *Mapping, flowName string) (err error) {
+	if len(rs.Region) > 0 {
+		m.Region = rs.Region
+	}
+	if rs.profileNameTemplate == nil {
+		return err
+	}
+	data, err := newProfileNameData(m.RoleArn, m.Region, flowName, m.parentConfig)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = rs.profileNameTemplate.Execute(&buf, data)
+	if err != nil {
+		return err
+	}
+	m.ProfileName = m.getProfileNameNormalization().apply(buf.String())
+	goslogger.Loggo.Debug("set profile name from role selector template", "roleArn", m.RoleArn, "profileName", m.ProfileName)
+	return err
+}
+
+// splitRolePath splits the path-qualified role name that comes from
+// parseRoleArn into the IAM path (e.g., '/oo/') and bare role name
+func splitRolePath(rolename string) (path, name string) {
+	i := strings.LastIndex(rolename, "/")
+	if i < 0 {
+		return "/", rolename
+	}
+	return "/" + rolename[:i+1], rolename[i+1:]
+}
+
+// validateSelectors compiles the include and exclude rules
+func (a *Assumptions) validateSelectors() (err error) {
+	for _, rules := range [][]*RoleSelector{a.Include, a.Exclude} {
+		for _, rs := range rules {
+			err = rs.compile()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// selectRole decides whether a role from the assertion that has no
+// explicit mapping should be assumed. It returns the include rule
+// that matched (if any) so its settings can be applied.
+func (a *Assumptions) selectRole(roleArn string) (ok bool, rule *RoleSelector) {
+	for _, rs := range a.Exclude {
+		if rs.matches(roleArn) {
+			goslogger.Loggo.Debug("role matched exclude rule", "roleArn", roleArn)
+			return false, rule
+		}
+	}
+	if len(a.Include) < 1 {
+		return a.AllRoles, rule
+	}
+	for _, rs := range a.Include {
+		if rs.matches(roleArn) {
+			goslogger.Loggo.Debug("role matched include rule", "roleArn", roleArn)
+			return true, rs
+		}
+	}
+	return false, rule
+}
@@ -0,0 +1,70 @@
+package gossamer
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSelectRole(t *testing.T) {
+	initLog()
+	a := Assumptions{
+		Include: []*RoleSelector{
+			{Account: "1111*", RoleName: "*-readonly", ProfileNameTemplate: "dev-{{.AccountNumber}}-{{.RoleName}}", Region: "us-west-2"},
+			{Arn: `arn:aws:iam::\d{12}:role/ops/.*`, Regex: true},
+		},
+		Exclude: []*RoleSelector{
+			{RoleName: "billing-*"},
+		},
+	}
+	if err := a.validateSelectors(); err != nil {
+		t.Fatalf("unexpected error compiling selectors: %s", err)
+	}
+	cases := []struct {
+		roleArn     string
+		selected    bool
+		profileName string
+	}{
+		{roleArn: "arn:aws:iam::111122223333:role/app-readonly", selected: true, profileName: "dev-111122223333-app-readonly"},
+		{roleArn: "arn:aws:iam::111122223333:role/path/app-readonly", selected: true, profileName: "dev-111122223333-app-readonly"},
+		{roleArn: "arn:aws:iam::111122223333:role/app-admin", selected: false},
+		{roleArn: "arn:aws:iam::999922223333:role/app-readonly", selected: false},
+		{roleArn: "arn:aws:iam::999922223333:role/ops/admin", selected: true},
+		{roleArn: "arn:aws:iam::111122223333:role/billing-readonly", selected: false},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		selected, rule := a.selectRole(c.roleArn)
+		if selected != c.selected {
+			t.Errorf("unexpected selection for '%s': want %v, got %v", c.roleArn, c.selected, selected)
+			continue
+		}
+		if !selected || rule == nil {
+			continue
+		}
+		m := Mapping{RoleArn: c.roleArn}
+		if err := rule.apply(&m, "test-flow"); err != nil {
+			t.Errorf("unexpected error applying rule: %s", err)
+		}
+		if m.ProfileName != c.profileName {
+			t.Errorf("unexpected profile name: want '%s', got '%s'", c.profileName, m.ProfileName)
+		}
+	}
+}
+
+func TestSelectRoleAllRoles(t *testing.T) {
+	initLog()
+	a := Assumptions{AllRoles: true, Exclude: []*RoleSelector{{RolePath: "/service-role/"}}}
+	if err := a.validateSelectors(); err != nil {
+		t.Fatalf("unexpected error compiling selectors: %s", err)
+	}
+	if ok, _ := a.selectRole("arn:aws:iam::111122223333:role/admin"); !ok {
+		t.Error("expected all_roles to select role with no include rules")
+	}
+	if ok, _ := a.selectRole("arn:aws:iam::111122223333:role/service-role/admin"); ok {
+		t.Error("expected exclude rule to win over all_roles")
+	}
+	bad := Assumptions{Include: []*RoleSelector{{Region: "us-east-1"}}}
+	if err := bad.validateSelectors(); err == nil {
+		t.Error("expected error for selector with no patterns")
+	}
+}
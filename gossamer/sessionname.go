@@ -0,0 +1,209 @@
+package gossamer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+)
+
+// maxRoleSessionNameLength is the longest RoleSessionName STS accepts
+const maxRoleSessionNameLength = 64
+
+var roleSessionNameDisallowed = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// roleSessionNameData holds the values that can be referenced
+// from a role_session_name template
+type roleSessionNameData struct {
+	CallerArn     string // ARN of the identity that started the flow
+	CallerName    string // last part of CallerArn (e.g., the IAM user name)
+	Account       string // account of the identity that started the flow
+	User          string // OS user running gossamer
+	Hostname      string
+	FlowName      string
+	ProfileName   string // only set for mapping level templates
+	InheritedName string // the name the mapping would have used otherwise
+}
+
+// newRoleSessionNameData fills in everything but the mapping
+// specific values for the flow
+func (f *Flow) newRoleSessionNameData(inherited string) roleSessionNameData {
+	data := roleSessionNameData{
+		CallerArn:     f.callerArn,
+		FlowName:      f.Name,
+		InheritedName: inherited,
+	}
+	if len(f.callerArn) > 0 {
+		arnParts := strings.Split(f.callerArn, "/")
+		data.CallerName = arnParts[len(arnParts)-1]
+		if fields := strings.Split(f.callerArn, ":"); len(fields) > 4 {
+			data.Account = fields[4]
+		}
+	}
+	if u, err := user.Current(); err == nil {
+		data.User = u.Username
+	}
+	if h, err := os.Hostname(); err == nil {
+		data.Hostname = h
+	}
+	return data
+}
+
+// parseRoleSessionNameTemplate parses a role_session_name template
+// failing on any reference to an unknown variable
+func parseRoleSessionNameTemplate(tmpl string) (t *template.Template, err error) {
+	t, err = template.New("role_session_name").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		msg := fmt.Sprintf("error parsing role_session_name template '%s': %s", tmpl, err)
+		err = errors.New(msg)
+	}
+	return t, err
+}
+
+// executeRoleSessionName executes the template and replaces any
+// characters STS doesn't allow without truncating the result
+func executeRoleSessionName(tmpl string, data roleSessionNameData) (name string, err error) {
+	t, err := parseRoleSessionNameTemplate(tmpl)
+	if err != nil {
+		return name, err
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		msg := fmt.Sprintf("error rendering role_session_name template '%s': %s", tmpl, err)
+		err = errors.New(msg)
+		return name, err
+	}
+	return roleSessionNameDisallowed.ReplaceAllString(strings.TrimSpace(buf.String()), "-"), err
+}
+
+// renderRoleSessionName executes the template and sanitizes the result
+func renderRoleSessionName(tmpl string, data roleSessionNameData) (name string, err error) {
+	name, err = executeRoleSessionName(tmpl, data)
+	if err != nil {
+		return name, err
+	}
+	name = sanitizeRoleSessionName(name)
+	if len(name) < 2 {
+		msg := fmt.Sprintf("role_session_name template '%s' rendered to '%s' which is shorter than the 2 characters STS requires", tmpl, name)
+		err = errors.New(msg)
+	}
+	return name, err
+}
+
+// sanitizeRoleSessionName replaces any characters STS doesn't allow
+// in a RoleSessionName with dashes and truncates it to the max length
+func sanitizeRoleSessionName(name string) string {
+	name = roleSessionNameDisallowed.ReplaceAllString(strings.TrimSpace(name), "-")
+	if len(name) > maxRoleSessionNameLength {
+		name = name[:maxRoleSessionNameLength]
+	}
+	return name
+}
+
+// checkRoleSessionNameTemplate renders the template with sample data so
+// unknown fields and names that are too short fail before anything is
+// assumed instead of when the template is used. Long names are truncated
+// the same way they are at runtime.
+func checkRoleSessionNameTemplate(tmpl string, data roleSessionNameData) (name string, err error) {
+	name, err = executeRoleSessionName(tmpl, data)
+	if err != nil {
+		return name, err
+	}
+	if len(name) > maxRoleSessionNameLength {
+		goslogger.Loggo.Warn("role_session_name will be truncated to the STS limit", "template", tmpl, "sample", name, "limit", maxRoleSessionNameLength)
+	}
+	return renderRoleSessionName(tmpl, data)
+}
+
+// validateRoleSessionNames makes sure every role_session_name template in
+// the flow renders. Values only known once the flow runs get samples.
+func (f *Flow) validateRoleSessionNames() (err error) {
+	data := f.newRoleSessionNameData("gossamer-session")
+	if len(data.CallerArn) < 1 {
+		data.CallerArn = "arn:aws:iam::123456789012:user/gossamer"
+		data.CallerName = "gossamer"
+		data.Account = "123456789012"
+	}
+	if len(f.RoleSessionName) > 0 {
+		if f.SAMLConfig != nil {
+			goslogger.Loggo.Warn("role_session_name on a SAML flow is ignored since the IdP sets it for primary assumptions, set it on mappings instead", "flow", f.Name)
+		}
+		data.InheritedName, err = checkRoleSessionNameTemplate(f.RoleSessionName, data)
+		if err != nil {
+			return err
+		}
+	}
+	for _, a := range []*Assumptions{f.PAss, f.SAss} {
+		if a == nil {
+			continue
+		}
+		// discovered mappings use the discovery's template
+		mappings := append([]Mapping{}, a.Mappings...)
+		if a.Organizations != nil {
+			mappings = append(mappings, a.Organizations.Mapping)
+		}
+		if a.DiscoverRoles != nil {
+			mappings = append(mappings, a.DiscoverRoles.Mapping)
+		}
+		for i := range mappings {
+			m := &mappings[i]
+			if len(m.RoleSessionName) < 1 {
+				continue
+			}
+			data.ProfileName = m.ProfileName
+			if len(data.ProfileName) < 1 {
+				data.ProfileName = m.mappingID()
+			}
+			_, err = checkRoleSessionNameTemplate(m.RoleSessionName, data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// setPrimaryRoleSessionName sets the name used by the primary assumptions
+// from the flow's template or falls back to the given default. Secondaries
+// pick it up from there unless their mappings say otherwise.
+func (f *Flow) setPrimaryRoleSessionName(fallback string) (err error) {
+	name := fallback
+	if len(f.RoleSessionName) > 0 {
+		name, err = renderRoleSessionName(f.RoleSessionName, f.newRoleSessionNameData(fallback))
+		if err != nil {
+			return err
+		}
+		goslogger.Loggo.Debug("rendered flow role session name", "flow", f.Name, "roleSessionName", name)
+	}
+	f.PAss.setRoleSessionName(name)
+	return err
+}
+
+// getRoleSessionName returns the name to use when assuming the mapping.
+// Mappings inherit the name from their assumptions unless they have
+// their own role_session_name template.
+func (m *Mapping) getRoleSessionName() (name *string, err error) {
+	inherited := m.parentAssumptions.getRoleSessionName()
+	if len(m.RoleSessionName) < 1 {
+		return inherited, err
+	}
+	f := m.parentFlow
+	if f == nil {
+		f = &Flow{}
+	}
+	data := f.newRoleSessionNameData(*inherited)
+	data.ProfileName = m.ProfileName
+	rendered, err := renderRoleSessionName(m.RoleSessionName, data)
+	if err != nil {
+		return name, err
+	}
+	goslogger.Loggo.Debug("rendered mapping role session name", "roleArn", m.RoleArn, "roleSessionName", rendered)
+	return &rendered, err
+}
@@ -0,0 +1,157 @@
+package gossamer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRenderRoleSessionName(t *testing.T) {
+	initLog()
+	data := roleSessionNameData{
+		CallerName:  "cool-dude",
+		User:        `CORP\jdoe`,
+		Hostname:    "laptop 01",
+		FlowName:    "prod",
+		ProfileName: "admin",
+	}
+	cases := []struct {
+		tmpl        string
+		result      string
+		errContains string
+	}{
+		{
+			tmpl:   "{{.User}}@{{.Hostname}}-{{.FlowName}}",
+			result: "CORP-jdoe@laptop-01-prod",
+		},
+		{
+			tmpl:   "{{.CallerName}}+{{.ProfileName}}=ok,yes.",
+			result: "cool-dude+admin=ok,yes.",
+		},
+		{
+			// truncated to the STS limit
+			tmpl:   strings.Repeat("x", 70),
+			result: strings.Repeat("x", maxRoleSessionNameLength),
+		},
+		{
+			tmpl:        "{{.Nope}}",
+			errContains: "error rendering",
+		},
+		{
+			tmpl:        "{{.User",
+			errContains: "error parsing",
+		},
+		{
+			tmpl:        "{{.Account}}",
+			errContains: "shorter than the 2 characters",
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		result, err := renderRoleSessionName(c.tmpl, data)
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("expected error containing '%s' but got '%v'", c.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if result != c.result {
+			t.Errorf("unexpected result: want '%s', got '%s'", c.result, result)
+		}
+	}
+}
+
+func TestMappingRoleSessionName(t *testing.T) {
+	initLog()
+	f := newTestChainFlow(
+		[]Mapping{{RoleArn: "arn:aws:iam::111111111111:role/hub", ProfileName: "hub"}},
+		[]Mapping{
+			{RoleArn: "arn:aws:iam::222222222222:role/spoke", ProfileName: "spoke"},
+			{RoleArn: "arn:aws:iam::333333333333:role/workload", ProfileName: "workload", RoleSessionName: "{{.InheritedName}}-{{.ProfileName}}"},
+		},
+	)
+	f.RoleSessionName = "{{.CallerName}}-{{.Account}}-{{.FlowName}}"
+	f.callerArn = "arn:aws:iam::111111111111:user/cool-dude"
+	err := f.setPrimaryRoleSessionName("gossamer-cool-dude")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := *f.PAss.getRoleSessionName(); got != "cool-dude-111111111111-chain" {
+		t.Errorf("unexpected primary role session name '%s'", got)
+	}
+	// secondaries inherit from the primaries as executeSecondary does
+	f.SAss.setRoleSessionName(*f.PAss.getRoleSessionName())
+	cases := []string{"cool-dude-111111111111-chain", "cool-dude-111111111111-chain-workload"}
+	for i, want := range cases {
+		fmt.Println("test case: ", i)
+		got, err := f.SAss.Mappings[i].getRoleSessionName()
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if *got != want {
+			t.Errorf("unexpected role session name: want '%s', got '%s'", want, *got)
+		}
+	}
+}
+
+func TestValidateRoleSessionNames(t *testing.T) {
+	initLog()
+	cases := []struct {
+		flowTmpl    string
+		mappingTmpl string
+		discovery   string
+		errContains string
+	}{
+		{flowTmpl: "{{.CallerName}}-{{.FlowName}}", mappingTmpl: "{{.InheritedName}}-{{.ProfileName}}"},
+		{flowTmpl: "{{.Caller}}", errContains: "can't evaluate field Caller"},
+		{mappingTmpl: "{{.ProfileName}}-{{.Nope}}", errContains: "can't evaluate field Nope"},
+		{discovery: "{{.Nope}}", errContains: "can't evaluate field Nope"},
+		// long names are truncated like they are at runtime
+		{mappingTmpl: strings.Repeat("x", 60) + "-{{.ProfileName}}"},
+		{flowTmpl: strings.Repeat("x", 40), mappingTmpl: "{{.InheritedName}}-" + strings.Repeat("y", 30)},
+		{mappingTmpl: "x", errContains: "shorter than the 2 characters"},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := newTestChainFlow(
+			[]Mapping{{RoleArn: "arn:aws:iam::111111111111:role/hub", ProfileName: "hub"}},
+			[]Mapping{{RoleArn: "arn:aws:iam::222222222222:role/spoke", ProfileName: "spoke", RoleSessionName: c.mappingTmpl}},
+		)
+		f.RoleSessionName = c.flowTmpl
+		if len(c.discovery) > 0 {
+			f.SAss.DiscoverRoles = &RoleDiscovery{Mapping: Mapping{RoleSessionName: c.discovery}}
+		}
+		err := f.validateRoleSessionNames()
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("expected error containing '%s' but got '%v'", c.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+}
+
+func TestCheckRoleSessionNameTemplateLongHostname(t *testing.T) {
+	initLog()
+	data := roleSessionNameData{
+		User:     "jdoe",
+		Hostname: "build-agent-0042.us-east-1.compute.internal.corp.example.com",
+		FlowName: "prod",
+	}
+	name, err := checkRoleSessionNameTemplate("{{.User}}@{{.Hostname}}-{{.FlowName}}", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := "jdoe@build-agent-0042.us-east-1.compute.internal.corp.example.co"
+	if name != want {
+		t.Errorf("unexpected name: want '%s', got '%s'", want, name)
+	}
+}
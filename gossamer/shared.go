@@ -0,0 +1,187 @@
+package gossamer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/GESkunkworks/gossamer/goslogger"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// mappingID returns the unique ID of the mapping which is its profile
+// name or the name that will be generated for it when none is set
+func (m *Mapping) mappingID() string {
+	if len(m.ProfileName) > 0 {
+		return m.ProfileName
+	}
+	name, err := m.generateProfileName()
+	if err != nil {
+		return m.RoleArn
+	}
+	return name
+}
+
+// validateMappingIDs makes sure no two mappings in the flow would write
+// the same profile entry. The same role can be listed more than once as
+// long as each mapping has its own profile name.
+func (f *Flow) validateMappingIDs() (err error) {
+	seen := make(map[string]string)
+	var problems []string
+	for _, a := range []*Assumptions{f.PAss, f.SAss} {
+		if a == nil {
+			continue
+		}
+		for _, m := range a.Mappings {
+			id := m.mappingID()
+			if other, ok := seen[id]; ok {
+				problems = append(problems, fmt.Sprintf("'%s' is used by both '%s' and '%s'", id, other, m.RoleArn))
+				continue
+			}
+			seen[id] = m.RoleArn
+		}
+	}
+	if len(problems) > 0 {
+		msg := fmt.Sprintf("flow '%s' has duplicate profile names (give each mapping of the same role its own profile_name): %s",
+			f.Name, strings.Join(problems, "; "))
+		err = errors.New(msg)
+		return err
+	}
+	// mappings with regions write other names so check those don't collide
+	written := make(map[string]string)
+	for _, a := range []*Assumptions{f.PAss, f.SAss} {
+		if a == nil {
+			continue
+		}
+		for i := range a.Mappings {
+			m := &a.Mappings[i]
+			entries, err := m.profileEntries()
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if other, ok := written[e.profileName]; ok {
+					problems = append(problems, fmt.Sprintf("'%s' would be written for both '%s' and '%s'", e.profileName, other, m.mappingID()))
+					continue
+				}
+				written[e.profileName] = m.mappingID()
+			}
+		}
+	}
+	if len(problems) > 0 {
+		msg := fmt.Sprintf("flow '%s' has colliding profile entries: %s", f.Name, strings.Join(problems, "; "))
+		err = errors.New(msg)
+	}
+	return err
+}
+
+// addDiscoveredMappings appends discovered mappings to the assumptions
+// only if their names don't collide with the mappings already in the
+// flow. On a collision nothing is added so none of them get assumed.
+func (a *Assumptions) addDiscoveredMappings(added []Mapping) (err error) {
+	existing := a.Mappings
+	a.Mappings = append(append([]Mapping{}, existing...), added...)
+	err = a.setRelationships(a.parentFlow, a.parentConfig)
+	if err == nil {
+		err = a.parentFlow.validateMappingIDs()
+	}
+	if err != nil {
+		a.Mappings = existing
+	}
+	return err
+}
+
+// assumeKey describes everything that goes into the STS call for a
+// mapping. Mappings with the same key get the same credentials so they
+// only need to be assumed once. It needs the mapping to be validated so
+// the duration is filled in and uses the rendered role session name since
+// the same template can render differently per mapping.
+func (m *Mapping) assumeKey() (key string, err error) {
+	rsn, err := m.getRoleSessionName()
+	if err != nil {
+		return key, err
+	}
+	externalID := ""
+	if m.ExternalID != nil {
+		externalID = m.ExternalID.Source + ":" + m.ExternalID.Value
+	}
+	var tags []string
+	for k, v := range m.SessionTags {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	principal := m.samlPrincipalArn
+	if len(principal) < 1 {
+		principal = m.PrincipalArn
+	}
+	key = strings.Join([]string{
+		m.RoleArn,
+		principal,
+		fmt.Sprintf("%p", m.sponsor),
+		fmt.Sprintf("%d", m.DurationSeconds),
+		m.Duration,
+		m.policyJSON,
+		strings.Join(m.PolicyArns, ","),
+		aws.StringValue(rsn),
+		externalID,
+		strings.Join(tags, ","),
+		strings.Join(m.TransitiveTagKeys, ","),
+		m.SourceIdentity,
+	}, "|")
+	return key, err
+}
+
+// groupSharedAssumptions points every mapping that would make the same
+// STS call as an earlier mapping at that mapping so it can reuse its
+// credentials. This lets one assumption fan out into several profile
+// entries that only differ by name or region.
+func (a *Assumptions) groupSharedAssumptions() {
+	leaders := make(map[string]*Mapping)
+	for i := range a.Mappings {
+		m := &a.Mappings[i]
+		m.sharedFrom = nil
+		if m.skip {
+			continue
+		}
+		// mappings that can't be validated or keyed aren't shared and
+		// fail on their own when they're assumed
+		err := m.validate()
+		if err != nil {
+			continue
+		}
+		key, err := m.assumeKey()
+		if err != nil {
+			goslogger.Loggo.Debug("not sharing assumption of mapping", "roleArn", m.RoleArn, "error", err)
+			continue
+		}
+		if leader, ok := leaders[key]; ok {
+			goslogger.Loggo.Debug("mapping will reuse credentials of identical assumption",
+				"roleArn", m.RoleArn,
+				"profileName", m.ProfileName,
+				"sharedWith", leader.ProfileName,
+			)
+			m.sharedFrom = leader
+			continue
+		}
+		leaders[key] = m
+	}
+}
+
+// assumeShared fills in the mapping's credential from the mapping it
+// shares an assumption with instead of calling STS again
+func (m *Mapping) assumeShared() (err error) {
+	err = m.validate()
+	if err != nil {
+		return err
+	}
+	cred, err := m.sharedFrom.getCredential()
+	if err != nil {
+		msg := fmt.Sprintf("shared assumption failed for mapping '%s': %s", m.mappingID(), err)
+		err = errors.New(msg)
+		return err
+	}
+	m.credential = cred
+	goslogger.Loggo.Debug("reused credential from identical assumption", "profileName", m.ProfileName, "sharedWith", m.sharedFrom.ProfileName)
+	return err
+}
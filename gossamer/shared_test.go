@@ -0,0 +1,98 @@
+package gossamer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func TestValidateMappingIDs(t *testing.T) {
+	initLog()
+	prod := "arn:aws:iam::123456789012:role/prod"
+	cases := []struct {
+		primaries   []Mapping
+		errContains string
+	}{
+		{
+			primaries: []Mapping{
+				{RoleArn: prod, ProfileName: "prod-use1", Region: "us-east-1"},
+				{RoleArn: prod, ProfileName: "prod-euw1", Region: "eu-west-1"},
+			},
+		},
+		{
+			primaries: []Mapping{
+				{RoleArn: prod},
+				{RoleArn: prod, Region: "eu-west-1"},
+			},
+			errContains: "'123456789012_prod' is used by both",
+		},
+		{
+			primaries: []Mapping{
+				{RoleArn: prod, ProfileName: "prod"},
+				{RoleArn: "arn:aws:iam::123456789012:role/other", ProfileName: "prod"},
+			},
+			errContains: "'prod' is used by both",
+		},
+	}
+	for i, c := range cases {
+		fmt.Println("test case: ", i)
+		f := newTestChainFlow(c.primaries, nil)
+		err := f.validateMappingIDs()
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("expected error containing '%s' but got '%v'", c.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+}
+
+func TestGroupSharedAssumptions(t *testing.T) {
+	initLog()
+	prod := "arn:aws:iam::123456789012:role/prod"
+	f := newTestChainFlow([]Mapping{
+		{RoleArn: prod, ProfileName: "prod-use1", Region: "us-east-1"},
+		{RoleArn: prod, ProfileName: "prod-euw1", Region: "eu-west-1"},
+		{RoleArn: prod, ProfileName: "prod-long", DurationSeconds: 7200},
+		{RoleArn: prod, ProfileName: "prod-skipped", skip: true},
+		// same as the default once validated
+		{RoleArn: prod, ProfileName: "prod-hour", DurationSeconds: 3600},
+		// the template renders differently for each
+		{RoleArn: prod, ProfileName: "prod-named-a", RoleSessionName: "{{.ProfileName}}"},
+		{RoleArn: prod, ProfileName: "prod-named-b", RoleSessionName: "{{.ProfileName}}"},
+		{RoleArn: prod, ProfileName: "prod-ops-a", RoleSessionName: "ops"},
+		{RoleArn: prod, ProfileName: "prod-ops-b", RoleSessionName: "ops"},
+	}, nil)
+	f.PAss.atype = "primary"
+	f.PAss.groupSharedAssumptions()
+	leader := &f.PAss.Mappings[0]
+	expected := []*Mapping{nil, leader, nil, nil, leader, nil, nil, nil, &f.PAss.Mappings[7]}
+	for i, want := range expected {
+		if got := f.PAss.Mappings[i].sharedFrom; got != want {
+			t.Errorf("unexpected sharedFrom for '%s': want %v, got %v", f.PAss.Mappings[i].ProfileName, want, got)
+		}
+	}
+	// the shared mapping picks up the leader's credentials without STS
+	leader.credential = &sts.Credentials{AccessKeyId: aws.String("AKIASHARED")}
+	follower := &f.PAss.Mappings[1]
+	err := follower.assume()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if follower.credential != leader.credential || follower.Region != "eu-west-1" {
+		t.Errorf("expected shared credential with own region but got %v in '%s'", follower.credential, follower.Region)
+	}
+	// profile names win over role ARNs when looking up sponsors
+	if got := f.PAss.findMappingByRef("prod-long"); got != &f.PAss.Mappings[2] {
+		t.Errorf("expected lookup by profile name to find prod-long but got %v", got)
+	}
+	if got := f.PAss.findMappingByRef(prod); got != leader {
+		t.Errorf("expected lookup by ARN to find the first mapping but got %v", got)
+	}
+}
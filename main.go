@@ -22,6 +22,12 @@ func handle(err error) {
 var version string
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
 	var gfl gossamer.GossFlags
 	flag.StringVar(&gfl.ConfigFile, "c", "", "path to yml config file that overrides all other parameters")
 	flag.StringVar(&gfl.RolesFile, "rolesfile", "", "LEGACY: File that contains json list of roles to assume and add to file.")
@@ -29,13 +35,17 @@ func main() {
 	flag.StringVar(&gfl.OutFile, "o", "./gossamer_creds", "Output credentials file.")
 	flag.StringVar(&gfl.LogFile, "logfile", "gossamer.log.json", "JSON logfile location")
 	flag.StringVar(&gfl.LogLevel, "loglevel", "info", "Log level (info or debug)")
-	flag.StringVar(&gfl.Profile, "profile", "", "Cred file profile to use. This overrides the default of using standard AWS session workflow (env var, instance-profile, etc)")
+	flag.StringVar(&gfl.Profile, "profile", "", "Cred file profile to use. This overrides the default of using standard AWS session workflow (env var, instance-profile, etc). To pick which profiles to write use -profiles instead")
+	flag.StringVar(&gfl.ProfileSelector, "profiles", "", "comma separated profile names or role ARNs to write with -c (others in the file are left alone). Not to be confused with -profile which still names the cred file profile to use")
+	flag.StringVar(&gfl.FlowSelector, "flow", "", "comma separated names of the flows to run (default all)")
+	flag.StringVar(&gfl.TagSelector, "tag", "", "comma separated tags, only flows and mappings with one of these tags are written")
 	flag.StringVar(&gfl.SerialNumber, "serialnumber", "", "Serial number of MFA device")
 	flag.StringVar(&gfl.TokenCode, "tokencode", "", "Token code of mfa device.")
 	flag.StringVar(&gfl.Region, "region", "us-east-1", "desired region for the primary flow")
 	flag.StringVar(&gfl.ProfileEntryName, "entryname", "gossamer", "when used with single ARN this is the entry name that will be added to the creds file (e.g., 'test-env')")
 	flag.StringVar(&gfl.GeneratedConfigOutputFile, "generate", "", "translates command arguments into config file for those who wish to convert from legacy parameters to new config file format. Will also generate a sample config file when this parameter is passed the '@sample' value.")
 	flag.Int64Var(&gfl.SessionDuration, "duration", 3600, "Duration of token in seconds. Duration longer than 3600 seconds only supported by AWS when assuming a single role per tokencode. When assuming multiple roles from rolesfile max duration will always be 3600 as restricted by AWS. (min=900, max=[read AWS docs]) ")
+	flag.IntVar(&gfl.MaxConcurrentFlows, "parallel", 0, "maximum number of flows to run at once (overrides max_concurrent_flows in config, default 4)")
 	flag.BoolVar(&gfl.VersionFlag, "v", false, "print version and exit")
 	flag.BoolVar(&gfl.ForceRefresh, "force", false, "LEGACY: ignored and only included so it doesn't break 1.x commands")
 	//TODO: Add positional args as source type for CParam
@@ -66,38 +76,32 @@ func main() {
 		if err != nil {
 			fmt.Printf("Error parsing config file: '%s'.  Continuing with parameter defaults\n", err.Error())
 		}
+		// only run what was asked for on the command line
+		err = gc.Select(gossamer.NewSelection(gfl.FlowSelector, gfl.ProfileSelector, gfl.TagSelector))
+		handle(err)
 	}
+	// run all flows and collect their entries to write in one go
+	pfis, flowErr := gc.ExecuteFlows(gfl.MaxConcurrentFlows)
+	// set up session to write to credentials file
+	c, err := acfmgr.NewCredFileSession(gc.OutFile)
+	handle(err)
 	totalCount := 0
-	// fmt.Println(gc.Dump())
-	for _, flow := range gc.Flows {
-		// call valiate to make sure user didn't put crazy stuff in config
-		_, err = flow.Validate()
-		handle(err)
-		// set up session to write to credentials file
-		c, err := acfmgr.NewCredFileSession(gc.OutFile)
-		handle(err)
-		// regardless of the flow type we'll always run primary
-		err = flow.Execute()
-		handle(err)
-		// queue entries to write to file
-		goslogger.Loggo.Info("queueing primary assumptions to write to file")
-		pfis, err := flow.GetAcfmgrProfileInputs()
-		handle(err)
-		count := 0
-		for _, pfi := range pfis {
-			err = c.NewEntry(pfi)
-			if err == nil {
-				count++
-			}
+	for _, pfi := range pfis {
+		err = c.NewEntry(pfi)
+		if err == nil {
+			totalCount++
 		}
-
-		// write all entries from this flow to file
-		err = c.AssertEntries()
-		if err != nil {
-			goslogger.Loggo.Error("error writing cred entries to file", "err", err)
-		}
-		totalCount = totalCount + count
-		goslogger.Loggo.Info("Wrote flow entries to file", "count", count, "flow", flow.Name)
 	}
-	goslogger.Loggo.Info("done", "entries_written", totalCount)
+	// write all entries from all flows to file
+	err = c.AssertEntries()
+	if err != nil {
+		goslogger.Loggo.Error("error writing cred entries to file", "err", err)
+	}
+	// region and other settings also go to the AWS config file if one is configured
+	configCount, err := gc.WriteConfigFile()
+	if err != nil {
+		goslogger.Loggo.Error("error writing profiles to config file", "err", err)
+	}
+	goslogger.Loggo.Info("done", "entries_written", totalCount, "config_profiles_written", configCount)
+	handle(flowErr)
 }